@@ -1,24 +1,25 @@
 package main
 
 import (
-	"crypto/sha256"
-	"fmt"
-	"httpfromtcp/internal/headers"
+	"context"
+	"httpfromtcp/internal/fileserver"
+	"httpfromtcp/internal/middleware"
+	"httpfromtcp/internal/proxy"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/router"
 	"httpfromtcp/internal/server"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
+	"time"
 )
 
 const port = 42069
-const BUFFER_SIZE = 1_024
-const CHUNK_SIZE = 32
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+const PROXY_UPSTREAM = "https://httpbin.org"
 
 const YOUR_PROBLEM_RESPONSE string = `<html>
   <head>
@@ -50,182 +51,62 @@ const SUCCESS_RESPONSE string = `<html>
   </body>
 </html>`
 
-var proxyHandler server.Handler = func(w *response.Writer, req *request.Request) {
-	requestTarget := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin")
-	resp, err := http.Get(fmt.Sprintf("https://httpbin.org%s", requestTarget))
+func newProxyHandler() server.Handler {
+	p, err := proxy.New(PROXY_UPSTREAM)
 	if err != nil {
-		statusCode := response.StatusCodeInternalServerError
-		_ = w.WriteStatusLine(statusCode)
-		_ = w.WriteHeaders(response.GetDefaultHeaders(0))
-		return
-
-	}
-	defer resp.Body.Close()
-	buffer := make([]byte, BUFFER_SIZE)
-
-	if err := w.WriteStatusLine(response.StatusCodeOK); err != nil {
-		log.Printf("error writing status line: %v", err)
-		return
-	}
-
-	h := headers.NewHeaders()
-	h.Set("Content-Type", "text/plain")
-	h.Set("Transfer-Encoding", "chunked")
-	h.Set("Trailer", "X-Content-Sha256, X-Content-Length")
-	// h.Set("Connection", "close")
-	if err := w.WriteHeaders(h); err != nil {
-		log.Printf("error writing headers: %v", err)
-		return
-	}
-
-	bodyLength := 0
-	aggregatedBody := []byte{}
-	for {
-		bufferLength, err := resp.Body.Read(buffer)
-		bodyLength += bufferLength
-		aggregatedBody = append(aggregatedBody, buffer[:bufferLength]...)
-
-		i := 0
-		for i+CHUNK_SIZE < bufferLength {
-			_, chunkErr := w.WriteChunkedBody(buffer[i : i+CHUNK_SIZE])
-			if chunkErr != nil {
-				log.Printf("error writing chunk: %v", chunkErr)
-				return
-			}
-			i += CHUNK_SIZE
-		}
-		if bufferLength > i {
-			_, chunkErr := w.WriteChunkedBody(buffer[i:bufferLength])
-			if chunkErr != nil {
-				log.Printf("error writing chunk: %v", chunkErr)
-				return
-			}
-		}
-
-		if err == io.EOF {
-			_, chunkErr := w.WriteChunkedBodyDone()
-			if chunkErr != nil {
-				log.Printf("error writing chunk: %v", chunkErr)
-			}
-			break
-		}
-		if err != nil {
-			log.Printf("error reading from body: %v", err)
-			return
-		}
-	}
-
-	t := headers.NewHeaders()
-	t.Set("X-Content-Length", fmt.Sprintf("%d", bodyLength))
-	hash := sha256.Sum256(aggregatedBody)
-	t.Set("X-Content-Sha256", fmt.Sprintf("%x", hash))
-
-	if err := w.WriteTrailers(t); err != nil {
-		log.Printf("error writing trailers: %v", err)
-		return
+		log.Fatalf("Error configuring proxy: %v", err)
 	}
-
+	p.StripPrefix = "/httpbin"
+	p.ChecksumTrailers = true
+	return p.Serve
 }
 
-var videoHandler server.Handler = func(w *response.Writer, req *request.Request) {
-
-	body, err := os.ReadFile("assets/vim.mp4")
-	if err != nil {
-		log.Printf("error reading video file: %v", err)
-		return
-	}
-
-	if err := w.WriteStatusLine(response.StatusCodeOK); err != nil {
-		log.Printf("error writing status line: %v", err)
-		return
-	}
+var assets = fileserver.New("assets")
 
-	contentLength := len(body)
-	h := response.GetDefaultHeaders(contentLength)
-	h.Set("Content-Type", "video/mp4")
-	if err := w.WriteHeaders(h); err != nil {
-		log.Printf("error writing headers: %v", err)
-		return
-	}
-
-	n, err := w.WriteBody(body)
-	if err != nil {
-		log.Printf("error writing body: %v", err)
-		return
-	}
-	if n != contentLength {
-		log.Printf("wrote %d bytes instead of %d bytes as expected by content-length", n, contentLength)
-		return
-	}
+var videoHandler server.Handler = func(w *response.Writer, req *request.Request) {
+	assets.ServeFile(w, req, "vim.mp4")
 }
 
-var mainHandler server.Handler = func(w *response.Writer, req *request.Request) {
-	var statusCode response.StatusCode
-	var body []byte
-
-	requestTarget := req.RequestLine.RequestTarget
-
-	switch {
-	// httpbin case handled separately
-	case strings.HasPrefix(requestTarget, "/httpbin"):
-		proxyHandler(w, req)
-		return
-	case requestTarget == "/video" && req.RequestLine.Method == "GET":
-		videoHandler(w, req)
-		return
-
-	case requestTarget == "/yourproblem":
-		statusCode = response.StatusCodeBadRequest
-		body = []byte(YOUR_PROBLEM_RESPONSE)
-	case requestTarget == "/myproblem":
-		statusCode = response.StatusCodeInternalServerError
-		body = []byte(MY_PROBLEM_RESPONSE)
-	default:
-		statusCode = response.StatusCodeOK
-		body = []byte(SUCCESS_RESPONSE)
-	}
-
-	contentLength := len(body)
-	headers := response.GetDefaultHeaders(contentLength)
-	headers.Set("Content-Type", "text/html")
-
-	if err := w.WriteStatusLine(statusCode); err != nil {
-		log.Printf("error writing status line: %v", err)
-		return
-	}
-
-	if err := w.WriteHeaders(headers); err != nil {
-		log.Printf("error writing headers: %v", err)
-		return
-	}
-
-	if value, exists := headers.Get("Content-Length"); !exists || value == "0" {
-		return
-	}
+func htmlHandler(statusCode response.StatusCode, page string) server.Handler {
+	return server.AutoFramed(func(w *response.AutoWriter, req *request.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(statusCode)
+		if _, err := io.WriteString(w, page); err != nil {
+			log.Printf("error writing body: %v", err)
+		}
+	})
+}
 
-	n, err := w.WriteBody(body)
-	if err != nil {
-		log.Printf("error writing body: %v", err)
-		return
-	}
-	if n != contentLength {
-		log.Printf("wrote %d bytes instead of %d bytes as expected by content-length", n, contentLength)
-		return
-	}
+func newRouter() *router.Router {
+	rt := router.New()
+	rt.Use(middleware.Recover(), middleware.RequestID(), middleware.AccessLog(nil), middleware.Compress())
+	rt.Any("/httpbin/*", newProxyHandler())
+	rt.Get("/video", videoHandler)
+	rt.Handle("HEAD", "/video", videoHandler)
+	rt.Any("/yourproblem", htmlHandler(response.StatusCodeBadRequest, YOUR_PROBLEM_RESPONSE))
+	rt.Any("/myproblem", htmlHandler(response.StatusCodeInternalServerError, MY_PROBLEM_RESPONSE))
+	rt.Any("/*", htmlHandler(response.StatusCodeOK, SUCCESS_RESPONSE))
+	return rt
 }
 
 func main() {
 	log.SetFlags(log.Lshortfile)
 
-	server, err := server.Serve(port, mainHandler)
+	server, err := server.Serve(port, newRouter().Route)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
 	log.Println("Server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error draining connections: %v", err)
+		_ = server.Close()
+	}
 	log.Println("Server gracefully stopped")
 }
@@ -0,0 +1,360 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/router"
+	"httpfromtcp/internal/server"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const BUFFER_SIZE = 32 * 1_024
+const SNIFF_LENGTH = 512
+const INDEX_FILE = "index.html"
+
+// MAX_RANGES bounds the ranges served from one request; requests asking for
+// more, or for more bytes than the file holds, get the whole file instead.
+const MAX_RANGES = 32
+
+// FileServer serves the files under a directory. It answers GET and HEAD,
+// streams file contents, and handles Range and conditional requests.
+// FileServer.Serve is a server.Handler; mounted on a router wildcard route it
+// serves the captured part of the path, otherwise the whole path.
+type FileServer struct {
+	root string
+	// ListDirectories serves an HTML listing for directories without an
+	// index.html instead of 404.
+	ListDirectories bool
+}
+
+func New(root string) *FileServer {
+	return &FileServer{root: root}
+}
+
+func (fsrv *FileServer) Serve(w *response.Writer, req *request.Request) {
+	name, ok := req.Params[router.WILDCARD]
+	if !ok {
+		name = req.RequestLine.Path
+	}
+	fsrv.ServeFile(w, req, name)
+}
+
+// ServeFile serves the file called name, relative to the root. Names cannot
+// leave the root, whether through ".." or a symbolic link.
+func (fsrv *FileServer) ServeFile(w *response.Writer, req *request.Request, name string) {
+	if req.RequestLine.Method != "GET" && req.RequestLine.Method != "HEAD" {
+		body := []byte("Method Not Allowed")
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("Allow", "GET, HEAD")
+		_ = w.WriteStatusLine(response.StatusCodeMethodNotAllowed)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteBody(body)
+		return
+	}
+
+	root, err := os.OpenRoot(fsrv.root)
+	if err != nil {
+		log.Printf("error opening %s: %v", fsrv.root, err)
+		writeError(w, response.StatusCodeInternalServerError)
+		return
+	}
+	defer root.Close()
+
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		name = "."
+	}
+	file, info, err := open(root, name)
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	defer file.Close()
+
+	if info.IsDir() {
+		if !strings.HasSuffix(req.RequestLine.Path, "/") {
+			// relative links in the directory need the trailing slash
+			redirect(w, req.RequestLine.RawPath+"/")
+			return
+		}
+		index, indexInfo, err := open(root, path.Join(name, INDEX_FILE))
+		if err == nil {
+			defer index.Close()
+			serveContent(w, req, index, indexInfo)
+			return
+		}
+		if !fsrv.ListDirectories {
+			writeError(w, response.StatusCodeNotFound)
+			return
+		}
+		serveListing(w, file)
+		return
+	}
+	serveContent(w, req, file, info)
+}
+
+func open(root *os.Root, name string) (*os.File, fs.FileInfo, error) {
+	file, err := root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+func serveContent(w *response.Writer, req *request.Request, file *os.File, info fs.FileInfo) {
+	size := info.Size()
+	etag := fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), size)
+	lastModified := info.ModTime().UTC().Format(http.TimeFormat)
+
+	if notModified(req, etag, info.ModTime()) {
+		h := headers.NewHeaders()
+		h.Set("ETag", etag)
+		h.Set("Last-Modified", lastModified)
+		_ = w.WriteStatusLine(response.StatusCodeNotModified)
+		_ = w.WriteHeaders(h)
+		return
+	}
+
+	contentType, err := detectContentType(file, info.Name())
+	if err != nil {
+		log.Printf("error sniffing %s: %v", info.Name(), err)
+		writeError(w, response.StatusCodeInternalServerError)
+		return
+	}
+
+	h := response.GetDefaultHeaders(int(size))
+	h.Set("Content-Type", contentType)
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", lastModified)
+
+	rangeHeader, hasRange := req.Headers.Get("Range")
+	if !hasRange || !ifRangeMatches(req, etag, info.ModTime()) {
+		writeContent(w, req, response.StatusCodeOK, h, file)
+		return
+	}
+
+	ranges, err := parseRange(rangeHeader, size)
+	switch {
+	case errors.Is(err, errUnsatisfiableRange):
+		h = response.GetDefaultHeaders(0)
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		_ = w.WriteStatusLine(response.StatusCodeRangeNotSatisfiable)
+		_ = w.WriteHeaders(h)
+	case err != nil || len(ranges) > MAX_RANGES || rangesLength(ranges) > size:
+		// a Range header we cannot use is ignored, RFC 9110 section 14.2
+		writeContent(w, req, response.StatusCodeOK, h, file)
+	case len(ranges) == 1:
+		h.Set("Content-Length", strconv.FormatInt(ranges[0].length, 10))
+		h.Set("Content-Range", ranges[0].contentRange(size))
+		writeContent(w, req, response.StatusCodePartialContent, h, io.NewSectionReader(file, ranges[0].start, ranges[0].length))
+	default:
+		serveMultipart(w, req, h, file, contentType, size, ranges)
+	}
+}
+
+// serveMultipart sends several ranges as a multipart/byteranges body. The
+// part headers are built up front so the body can carry a Content-Length.
+func serveMultipart(w *response.Writer, req *request.Request, h *headers.Headers, file *os.File, contentType string, size int64, ranges []byteRange) {
+	boundary := make([]byte, 16)
+	_, _ = rand.Read(boundary)
+	delimiter := hex.EncodeToString(boundary)
+
+	partHeaders := make([]string, len(ranges))
+	length := int64(0)
+	for i, r := range ranges {
+		partHeaders[i] = fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n", delimiter, contentType, r.contentRange(size))
+		length += int64(len(partHeaders[i])) + r.length
+	}
+	closing := fmt.Sprintf("\r\n--%s--\r\n", delimiter)
+	length += int64(len(closing))
+
+	h.Set("Content-Type", "multipart/byteranges; boundary="+delimiter)
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	_ = w.WriteStatusLine(response.StatusCodePartialContent)
+	_ = w.WriteHeaders(h)
+	if req.RequestLine.Method == "HEAD" {
+		return
+	}
+	for i, r := range ranges {
+		if _, err := w.WriteBody([]byte(partHeaders[i])); err != nil {
+			return
+		}
+		if !copyBody(w, io.NewSectionReader(file, r.start, r.length)) {
+			return
+		}
+	}
+	_, _ = w.WriteBody([]byte(closing))
+}
+
+// writeContent sends a response whose body is read from body, which is left
+// unread for HEAD.
+func writeContent(w *response.Writer, req *request.Request, status response.StatusCode, h *headers.Headers, body io.Reader) {
+	if err := w.WriteStatusLine(status); err != nil {
+		log.Printf("error writing status line: %v", err)
+		return
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		log.Printf("error writing headers: %v", err)
+		return
+	}
+	if req.RequestLine.Method == "HEAD" {
+		return
+	}
+	copyBody(w, body)
+}
+
+// copyBody streams body into w, reporting whether all of it was written.
+func copyBody(w *response.Writer, body io.Reader) bool {
+	buffer := make([]byte, BUFFER_SIZE)
+	for {
+		n, err := body.Read(buffer)
+		if n > 0 {
+			if _, writeErr := w.WriteBody(buffer[:n]); writeErr != nil {
+				log.Printf("error writing body: %v", writeErr)
+				return false
+			}
+		}
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			log.Printf("error reading file: %v", err)
+			return false
+		}
+	}
+}
+
+// detectContentType picks a type by extension, falling back to sniffing the
+// first bytes of the file.
+func detectContentType(file *os.File, name string) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType, nil
+	}
+	buffer := make([]byte, SNIFF_LENGTH)
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buffer[:n]), nil
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when there is no
+// If-None-Match, as RFC 9110 section 13.2.2 orders them.
+func notModified(req *request.Request, etag string, modTime time.Time) bool {
+	if ifNoneMatch, ok := req.Headers.Get("If-None-Match"); ok {
+		return etagListMatches(ifNoneMatch, etag)
+	}
+	ifModifiedSince, ok := req.Headers.Get("If-Modified-Since")
+	if !ok {
+		return false
+	}
+	since, err := http.ParseTime(ifModifiedSince)
+	return err == nil && !modTime.Truncate(time.Second).After(since)
+}
+
+// ifRangeMatches reports whether a Range request may be served partially:
+// without If-Range, or when its validator still matches the file.
+func ifRangeMatches(req *request.Request, etag string, modTime time.Time) bool {
+	ifRange, ok := req.Headers.Get("If-Range")
+	if !ok {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		// If-Range needs a strong comparison
+		return ifRange == etag
+	}
+	date, err := http.ParseTime(ifRange)
+	return err == nil && modTime.Truncate(time.Second).Equal(date)
+}
+
+// etagListMatches compares the entity tags of an If-None-Match list with
+// etag using the weak comparison.
+func etagListMatches(list, etag string) bool {
+	for candidate := range strings.SplitSeq(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func serveListing(w *response.Writer, dir *os.File) {
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		log.Printf("error listing %s: %v", dir.Name(), err)
+		writeError(w, response.StatusCodeInternalServerError)
+		return
+	}
+
+	var listing strings.Builder
+	listing.WriteString("<html>\n  <body>\n    <ul>\n")
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		fmt.Fprintf(&listing, "      <li><a href=\"%s\">%s</a></li>\n", html.EscapeString((&url.URL{Path: name}).EscapedPath()), html.EscapeString(name))
+	}
+	listing.WriteString("    </ul>\n  </body>\n</html>\n")
+
+	body := []byte(listing.String())
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	_ = w.WriteStatusLine(response.StatusCodeOK)
+	_ = w.WriteHeaders(h)
+	_, _ = w.WriteBody(body)
+}
+
+func redirect(w *response.Writer, location string) {
+	h := response.GetDefaultHeaders(0)
+	h.Set("Location", location)
+	_ = w.WriteStatusLine(response.StatusCodeMovedPermanently)
+	_ = w.WriteHeaders(h)
+}
+
+func writeOpenError(w *response.Writer, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		writeError(w, response.StatusCodeNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		writeError(w, response.StatusCodeForbidden)
+	default:
+		// most often a symbolic link leading out of the root, which os.Root
+		// refuses; the file is not served either way
+		log.Printf("error opening file: %v", err)
+		writeError(w, response.StatusCodeNotFound)
+	}
+}
+
+func writeError(w *response.Writer, status response.StatusCode) {
+	server.HandlerError{
+		Status:  status,
+		Message: response.StatusText(status),
+	}.WriteError(w)
+}
@@ -0,0 +1,213 @@
+package fileserver
+
+import (
+	"httpfromtcp/internal/testutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const CONTENT = "hello, file server"
+
+func newRoot(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "hello.txt"), []byte(CONTENT), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "page"), []byte("<html><body>sniffed</body></html>"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "docs"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "a & b.txt"), []byte("a"), 0o644))
+	return root
+}
+
+func serve(t *testing.T, fsrv *FileServer, raw string) (string, string) {
+	t.Helper()
+	return testutil.SplitResponse(testutil.Serve(t, fsrv.Serve, raw))
+}
+
+func header(t *testing.T, head, name string) string {
+	t.Helper()
+	match := regexp.MustCompile("(?m)^" + name + ": (.*)\r$").FindStringSubmatch(head)
+	require.NotNil(t, match, "missing %s in %q", name, head)
+	return match[1]
+}
+
+func TestServeFile(t *testing.T) {
+	fsrv := New(newRoot(t))
+
+	head, body := serve(t, fsrv, testutil.RawRequest("GET", "/hello.txt"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, CONTENT, body)
+	assert.Equal(t, strconv.Itoa(len(CONTENT)), header(t, head, "Content-Length"))
+	assert.Equal(t, "text/plain; charset=utf-8", header(t, head, "Content-Type"))
+	assert.Equal(t, "bytes", header(t, head, "Accept-Ranges"))
+	assert.NotEmpty(t, header(t, head, "ETag"))
+	assert.NotEmpty(t, header(t, head, "Last-Modified"))
+
+	head, _ = serve(t, fsrv, testutil.RawRequest("GET", "/page"))
+	assert.Equal(t, "text/html; charset=utf-8", header(t, head, "Content-Type"))
+
+	head, body = serve(t, fsrv, testutil.RawRequest("HEAD", "/hello.txt"))
+	assert.Equal(t, strconv.Itoa(len(CONTENT)), header(t, head, "Content-Length"))
+	assert.Empty(t, body)
+
+	head, _ = serve(t, fsrv, testutil.RawRequest("POST", "/hello.txt"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Equal(t, "GET, HEAD", header(t, head, "Allow"))
+
+	head, _ = serve(t, fsrv, testutil.RawRequest("GET", "/missing.txt"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 404 Not Found\r\n"))
+}
+
+func TestPathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0o644))
+	root := filepath.Join(dir, "public")
+	require.NoError(t, os.Mkdir(root, 0o755))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "secret"), filepath.Join(root, "link")))
+	fsrv := New(root)
+
+	for _, target := range []string{"/../secret", "/%2e%2e/secret", "/a/../../secret", "/link"} {
+		head, body := serve(t, fsrv, testutil.RawRequest("GET", target))
+		assert.True(t, strings.HasPrefix(head, "HTTP/1.1 404 Not Found\r\n"), target)
+		assert.NotContains(t, body, "secret", target)
+	}
+}
+
+func TestRange(t *testing.T) {
+	fsrv := New(newRoot(t))
+
+	head, body := serve(t, fsrv, testutil.RawRequest("GET", "/hello.txt", "Range: bytes=0-4"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, "bytes 0-4/18", header(t, head, "Content-Range"))
+	assert.Equal(t, "5", header(t, head, "Content-Length"))
+
+	_, body = serve(t, fsrv, testutil.RawRequest("GET", "/hello.txt", "Range: bytes=-6"))
+	assert.Equal(t, "server", body)
+
+	_, body = serve(t, fsrv, testutil.RawRequest("GET", "/hello.txt", "Range: bytes=7-"))
+	assert.Equal(t, "file server", body)
+
+	head, _ = serve(t, fsrv, testutil.RawRequest("GET", "/hello.txt", "Range: bytes=100-200"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 416 Range Not Satisfiable\r\n"))
+	assert.Equal(t, "bytes */18", header(t, head, "Content-Range"))
+
+	head, body = serve(t, fsrv, testutil.RawRequest("GET", "/hello.txt", "Range: lines=1-2"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, CONTENT, body)
+}
+
+func TestMultipartRange(t *testing.T) {
+	fsrv := New(newRoot(t))
+
+	head, body := serve(t, fsrv, testutil.RawRequest("GET", "/hello.txt", "Range: bytes=0-4, 12-17"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 206 Partial Content\r\n"))
+	contentType := header(t, head, "Content-Type")
+	boundary, found := strings.CutPrefix(contentType, "multipart/byteranges; boundary=")
+	require.True(t, found, contentType)
+	assert.Equal(t, strconv.Itoa(len(body)), header(t, head, "Content-Length"))
+
+	assert.Contains(t, body, "--"+boundary+"\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Range: bytes 0-4/18\r\n\r\nhello\r\n")
+	assert.Contains(t, body, "Content-Range: bytes 12-17/18\r\n\r\nserver\r\n")
+	assert.True(t, strings.HasSuffix(body, "--"+boundary+"--\r\n"))
+}
+
+func TestOverlappingRangesServeWholeFile(t *testing.T) {
+	fsrv := New(newRoot(t))
+
+	head, body := serve(t, fsrv, testutil.RawRequest("GET", "/hello.txt", "Range: bytes=0-,0-"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, CONTENT, body)
+
+	head, body = serve(t, fsrv, testutil.RawRequest("GET", "/hello.txt", "Range: bytes=0-9, 5-14"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, CONTENT, body)
+}
+
+func TestConditionalRequests(t *testing.T) {
+	fsrv := New(newRoot(t))
+	head, _ := serve(t, fsrv, testutil.RawRequest("GET", "/hello.txt"))
+	etag := header(t, head, "ETag")
+	lastModified := header(t, head, "Last-Modified")
+
+	head, body := serve(t, fsrv, testutil.RawRequest("GET", "/hello.txt", "If-None-Match: \"other\", "+etag))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Equal(t, etag, header(t, head, "ETag"))
+	assert.Empty(t, body)
+
+	head, _ = serve(t, fsrv, testutil.RawRequest("GET", "/hello.txt", "If-None-Match: W/"+etag))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 304 Not Modified\r\n"))
+
+	head, _ = serve(t, fsrv, testutil.RawRequest("GET", "/hello.txt", "If-Modified-Since: "+lastModified))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 304 Not Modified\r\n"))
+
+	head, _ = serve(t, fsrv, testutil.RawRequest("GET", "/hello.txt", "If-Modified-Since: Mon, 01 Jan 2001 00:00:00 GMT"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+
+	// If-None-Match takes precedence over If-Modified-Since
+	head, _ = serve(t, fsrv, testutil.RawRequest("GET", "/hello.txt", "If-None-Match: \"other\"", "If-Modified-Since: "+lastModified))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+
+	head, _ = serve(t, fsrv, testutil.RawRequest("GET", "/hello.txt", "Range: bytes=0-4", "If-Range: "+etag))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 206 Partial Content\r\n"))
+
+	head, body = serve(t, fsrv, testutil.RawRequest("GET", "/hello.txt", "Range: bytes=0-4", "If-Range: \"stale\""))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, CONTENT, body)
+}
+
+func TestDirectories(t *testing.T) {
+	root := newRoot(t)
+	fsrv := New(root)
+
+	head, _ := serve(t, fsrv, testutil.RawRequest("GET", "/docs"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Equal(t, "/docs/", header(t, head, "Location"))
+
+	head, _ = serve(t, fsrv, testutil.RawRequest("GET", "/docs/"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 404 Not Found\r\n"))
+
+	fsrv.ListDirectories = true
+	head, body := serve(t, fsrv, testutil.RawRequest("GET", "/docs/"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, body, `<a href="a%20&amp;%20b.txt">a &amp; b.txt</a>`)
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", INDEX_FILE), []byte("index"), 0o644))
+	_, body = serve(t, fsrv, testutil.RawRequest("GET", "/docs/"))
+	assert.Equal(t, "index", body)
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		ranges []byteRange
+		err    error
+	}{
+		{"bytes=0-0", []byteRange{{0, 1}}, nil},
+		{"bytes=5-", []byteRange{{5, 5}}, nil},
+		{"bytes=-3", []byteRange{{7, 3}}, nil},
+		{"bytes=-30", []byteRange{{0, 10}}, nil},
+		{"bytes=8-20", []byteRange{{8, 2}}, nil},
+		{"bytes=0-1, 4-5", []byteRange{{0, 2}, {4, 2}}, nil},
+		{"bytes=20-30, 0-1", []byteRange{{0, 2}}, nil},
+		{"bytes=20-30", nil, errUnsatisfiableRange},
+		{"bytes=-0", nil, errUnsatisfiableRange},
+		{"bytes=5-1", nil, errInvalidRange},
+		{"bytes=a-b", nil, errInvalidRange},
+		{"bytes=+1-2", nil, errInvalidRange},
+		{"items=0-1", nil, errInvalidRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			ranges, err := parseRange(tt.header, 10)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.ranges, ranges)
+		})
+	}
+}
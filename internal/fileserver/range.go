@@ -0,0 +1,101 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var errInvalidRange = errors.New("invalid Range header")
+var errUnsatisfiableRange = errors.New("no satisfiable range")
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a bytes Range header, RFC 9110 section 14.1.2, against a
+// representation of size bytes. Ranges past the end are dropped, and
+// errUnsatisfiableRange is returned when none is left.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	specs, found := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !found {
+		return nil, errInvalidRange
+	}
+
+	var ranges []byteRange
+	for spec := range strings.SplitSeq(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, errInvalidRange
+		}
+
+		if first == "" {
+			// suffix range: the last n bytes
+			n, err := parseRangeInt(last)
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			ranges = append(ranges, byteRange{start: size - n, length: n})
+			continue
+		}
+
+		start, err := parseRangeInt(first)
+		if err != nil {
+			return nil, err
+		}
+		end := size - 1
+		if last != "" {
+			if end, err = parseRangeInt(last); err != nil {
+				return nil, err
+			}
+			if end < start {
+				return nil, errInvalidRange
+			}
+			end = min(end, size-1)
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+// rangesLength sums the lengths of ranges. Overlapping ranges count once per
+// range, so a total over the representation size means repeated bytes.
+func rangesLength(ranges []byteRange) int64 {
+	total := int64(0)
+	for _, r := range ranges {
+		total += r.length
+	}
+	return total
+}
+
+func parseRangeInt(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, errInvalidRange
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errInvalidRange
+	}
+	return n, nil
+}
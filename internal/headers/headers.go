@@ -2,11 +2,24 @@ package headers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"iter"
 	"strings"
 )
 
-type Headers map[string]string
+// Field is a single header line. Name keeps the casing it was given.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Headers keeps header fields in the order they were added, one entry per
+// field line, so repeated fields such as Set-Cookie survive untouched. Names
+// are matched case-insensitively.
+type Headers struct {
+	fields []Field
+}
 
 const CRLF = "\r\n"
 const CRLF_LENGTH = 2
@@ -14,15 +27,42 @@ const COLON = ":"
 
 const STANDARD_RUNES = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!#$%&'*+-.^_`|~"
 
-func NewHeaders() Headers {
-	return make(Headers)
+var ErrMissingColon = errors.New("invalid header does not contain colon separator")
+var ErrInvalidFieldName = errors.New("invalid header field name")
+var ErrInvalidFieldValue = errors.New("invalid header field value")
+
+func NewHeaders() *Headers {
+	return &Headers{}
 }
 
 func invalidRune(r rune) bool {
 	return !strings.ContainsRune(STANDARD_RUNES, r)
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+// invalidValueByte rejects the control characters RFC 9110 leaves out of
+// field values: everything below SP except HTAB, and DEL. obs-text bytes are
+// allowed.
+func invalidValueByte(b byte) bool {
+	return (b < ' ' && b != '\t') || b == 0x7f
+}
+
+// ValidFieldName reports whether name is a non-empty token.
+func ValidFieldName(name string) bool {
+	return name != "" && !strings.ContainsFunc(name, invalidRune)
+}
+
+// ValidFieldValue reports whether value can be sent on a field line without
+// changing how the message is framed.
+func ValidFieldValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if invalidValueByte(value[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
 	index := bytes.Index(data, []byte(CRLF))
 	if index == -1 {
 		return 0, false, nil
@@ -32,37 +72,119 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		return CRLF_LENGTH, true, nil
 	}
 
-	key, value, found := strings.Cut(string(data[:index]), COLON)
+	line := string(data[:index])
+	if line[0] == ' ' || line[0] == '\t' {
+		if len(h.fields) == 0 {
+			// RFC 9112 section 2.2: a first field line starting with
+			// whitespace could smuggle a field past other parsers
+			return 0, false, fmt.Errorf("%w: first field line starts with whitespace", ErrInvalidFieldName)
+		}
+		// obs-fold: RFC 9112 lets a recipient replace the fold with SP
+		continuation := strings.Trim(line, " \t")
+		if !ValidFieldValue(continuation) {
+			return 0, false, fmt.Errorf("%w: folded line contains control characters", ErrInvalidFieldValue)
+		}
+		last := &h.fields[len(h.fields)-1]
+		if last.Value == "" {
+			last.Value = continuation
+		} else if continuation != "" {
+			last.Value += " " + continuation
+		}
+		return index + CRLF_LENGTH, false, nil
+	}
+
+	key, value, found := strings.Cut(line, COLON)
 	if !found {
-		return 0, false, fmt.Errorf("invalid header does not contain colon separator")
+		return 0, false, ErrMissingColon
 	}
 
 	if key != strings.TrimRight(key, " \t\n\r") {
-		return 0, false, fmt.Errorf("invalid header key contains trailing whitespace")
+		return 0, false, fmt.Errorf("%w: key contains trailing whitespace", ErrInvalidFieldName)
 	}
 
 	key = strings.TrimSpace(key)
-	if strings.ContainsFunc(key, invalidRune) {
-		return 0, false, fmt.Errorf("invalid header key contains invalid characters")
+	if !ValidFieldName(key) {
+		return 0, false, fmt.Errorf("%w: key contains invalid characters", ErrInvalidFieldName)
 	}
 
-	key = strings.ToLower(key)
-
-	_, exists := h[key]
-	if !exists {
-		h[key] = strings.TrimSpace(value)
-	} else {
-		h[key] = fmt.Sprintf("%s, %s", h[key], strings.TrimSpace(value))
+	value = strings.Trim(value, " \t")
+	if !ValidFieldValue(value) {
+		return 0, false, fmt.Errorf("%w: value of %s contains control characters", ErrInvalidFieldValue, key)
 	}
 
+	h.Add(key, value)
+
 	return index + CRLF_LENGTH, false, nil
 }
 
-func (h Headers) Get(key string) (string, bool) {
-	value, ok := h[strings.ToLower(key)]
-	return value, ok
+// Get returns every value of the field joined by ", ".
+func (h *Headers) Get(key string) (string, bool) {
+	values := h.Values(key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return strings.Join(values, ", "), true
+}
+
+// Set replaces every value of the field with value, keeping the position of
+// its first occurrence.
+func (h *Headers) Set(key, value string) {
+	for i := range h.fields {
+		if strings.EqualFold(h.fields[i].Name, key) {
+			h.fields[i] = Field{Name: key, Value: value}
+			h.fields = append(h.fields[:i+1], deleteFields(h.fields[i+1:], key)...)
+			return
+		}
+	}
+	h.Add(key, value)
+}
+
+// Add appends a field line, keeping any previous values of the field.
+func (h *Headers) Add(key, value string) {
+	h.fields = append(h.fields, Field{Name: key, Value: value})
+}
+
+// Values returns the value of each line of the field, in order.
+func (h *Headers) Values(key string) []string {
+	var values []string
+	for _, field := range h.fields {
+		if strings.EqualFold(field.Name, key) {
+			values = append(values, field.Value)
+		}
+	}
+	return values
+}
+
+func (h *Headers) Del(key string) {
+	h.fields = deleteFields(h.fields, key)
 }
 
-func (h Headers) Set(key, value string) {
-	h[strings.ToLower(key)] = value
+func (h *Headers) Clone() *Headers {
+	return &Headers{fields: append([]Field(nil), h.fields...)}
+}
+
+// Len returns the number of field lines.
+func (h *Headers) Len() int {
+	return len(h.fields)
+}
+
+// All iterates over the field lines in insertion order.
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		for _, field := range h.fields {
+			if !yield(field.Name, field.Value) {
+				return
+			}
+		}
+	}
+}
+
+func deleteFields(fields []Field, key string) []Field {
+	kept := fields[:0]
+	for _, field := range fields {
+		if !strings.EqualFold(field.Name, key) {
+			kept = append(kept, field)
+		}
+	}
+	return kept
 }
@@ -7,13 +7,18 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+func get(h *Headers, key string) string {
+	value, _ := h.Get(key)
+	return value
+}
+
 func TestValidSingleHeader(t *testing.T) {
 	headers := NewHeaders()
 	data := []byte("Host: localhost:42069\r\n\r\n")
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", get(headers, "host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 }
@@ -58,12 +63,12 @@ func TestInvalidHeaderKey(t *testing.T) {
 
 func TestValidSingleHeaderWithExtraWhitespace(t *testing.T) {
 	headers := NewHeaders()
-	data := []byte(" Host:   localhost:42069  \r\n\r\n")
+	data := []byte("Host:   localhost:42069  \r\n\r\n")
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
-	assert.Equal(t, 28, n)
+	assert.Equal(t, "localhost:42069", get(headers, "host"))
+	assert.Equal(t, 27, n)
 	assert.False(t, done)
 }
 
@@ -83,7 +88,7 @@ func TestValidTwoHeaders(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", get(headers, "host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
@@ -91,7 +96,7 @@ func TestValidTwoHeaders(t *testing.T) {
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "Bar", headers["foo"])
+	assert.Equal(t, "Bar", get(headers, "foo"))
 	assert.Equal(t, 10, n)
 	assert.False(t, done)
 
@@ -109,7 +114,7 @@ func TestMultipleValues(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", get(headers, "host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
@@ -117,7 +122,118 @@ func TestMultipleValues(t *testing.T) {
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069, localhost:42070", headers["host"])
+	assert.Equal(t, "localhost:42069, localhost:42070", get(headers, "host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 }
+
+func TestPreservesOrderAndCasing(t *testing.T) {
+	headers := NewHeaders()
+	data := []byte("Set-Cookie: a=1; Path=/\r\nX-Custom: one\r\nset-cookie: b=2, c=3\r\n\r\n")
+	for {
+		n, done, err := headers.Parse(data)
+		require.NoError(t, err)
+		data = data[n:]
+		if done {
+			break
+		}
+	}
+
+	assert.Equal(t, []string{"a=1; Path=/", "b=2, c=3"}, headers.Values("Set-Cookie"))
+
+	fields := []Field{}
+	for name, value := range headers.All() {
+		fields = append(fields, Field{Name: name, Value: value})
+	}
+	assert.Equal(t, []Field{
+		{Name: "Set-Cookie", Value: "a=1; Path=/"},
+		{Name: "X-Custom", Value: "one"},
+		{Name: "set-cookie", Value: "b=2, c=3"},
+	}, fields)
+}
+
+func TestSetReplacesAllValues(t *testing.T) {
+	headers := NewHeaders()
+	headers.Add("Accept", "text/html")
+	headers.Add("Vary", "Accept")
+	headers.Add("accept", "application/json")
+	headers.Set("Accept", "*/*")
+
+	assert.Equal(t, []string{"*/*"}, headers.Values("accept"))
+	assert.Equal(t, 2, headers.Len())
+
+	names := []string{}
+	for name := range headers.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"Accept", "Vary"}, names)
+}
+
+func TestDelAndClone(t *testing.T) {
+	headers := NewHeaders()
+	headers.Add("Set-Cookie", "a=1")
+	headers.Add("Set-Cookie", "b=2")
+	headers.Add("Host", "localhost")
+
+	clone := headers.Clone()
+	headers.Del("set-cookie")
+
+	_, ok := headers.Get("Set-Cookie")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"a=1", "b=2"}, clone.Values("Set-Cookie"))
+	assert.Equal(t, "localhost", get(clone, "host"))
+}
+
+func TestInvalidHeaderValue(t *testing.T) {
+	for _, data := range []string{
+		"X-Bad: foo\x00bar\r\n\r\n",
+		"X-Bad: foo\rbar\r\n\r\n",
+		"X-Bad: foo\nbar\r\n\r\n",
+		"X-Bad: foo\x7fbar\r\n\r\n",
+	} {
+		headers := NewHeaders()
+		n, done, err := headers.Parse([]byte(data))
+		require.ErrorIs(t, err, ErrInvalidFieldValue, "%q", data)
+		assert.Equal(t, 0, n)
+		assert.False(t, done)
+	}
+}
+
+func TestValueAllowsTabsAndObsText(t *testing.T) {
+	headers := NewHeaders()
+	_, _, err := headers.Parse([]byte("X-Name: caf\xc3\xa9\tbar\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "caf\xc3\xa9\tbar", get(headers, "x-name"))
+}
+
+func TestEmptyHeaderKey(t *testing.T) {
+	headers := NewHeaders()
+	_, _, err := headers.Parse([]byte(": value\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidFieldName)
+}
+
+func TestObsFoldIsReplacedWithSpace(t *testing.T) {
+	headers := NewHeaders()
+	data := []byte("X-Folded: first\r\n  second\r\n\tthird\r\n\r\n")
+	for {
+		n, done, err := headers.Parse(data)
+		require.NoError(t, err)
+		data = data[n:]
+		if done {
+			break
+		}
+	}
+	assert.Equal(t, "first second third", get(headers, "x-folded"))
+	assert.Equal(t, 1, headers.Len())
+}
+
+func TestWhitespaceBeforeFirstField(t *testing.T) {
+	for _, data := range []string{" Host: evil\r\n\r\n", "\tHost: evil\r\n\r\n"} {
+		headers := NewHeaders()
+		n, done, err := headers.Parse([]byte(data))
+		require.ErrorIs(t, err, ErrInvalidFieldName, "%q", data)
+		assert.Equal(t, 0, n)
+		assert.False(t, done)
+		assert.Equal(t, 0, headers.Len())
+	}
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// COMPRESS_MIN_LENGTH is the smallest declared Content-Length worth
+// compressing; below it the coding overhead eats the savings.
+const COMPRESS_MIN_LENGTH = 1_024
+
+// encoders are the content codings Compress offers, most preferred first.
+var encoders = []struct {
+	coding     string
+	newEncoder func(io.Writer) io.WriteCloser
+}{
+	{"gzip", func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }},
+	{"deflate", func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }},
+}
+
+// compressibleTypes are the media types worth compressing besides text/*
+// and the +json and +xml suffixes. Images, audio, video and archives are
+// already compressed.
+var compressibleTypes = map[string]bool{
+	"application/javascript":            true,
+	"application/json":                  true,
+	"application/xml":                   true,
+	"application/x-www-form-urlencoded": true,
+	"application/wasm":                  true,
+	"image/svg+xml":                     true,
+}
+
+// Compress compresses responses with gzip or deflate, whichever the client
+// prefers in Accept-Encoding. Only compressible content types are encoded,
+// and never bodies with a Content-Length under COMPRESS_MIN_LENGTH, partial
+// content, or responses already encoded or marked no-transform. Compressed
+// bodies are sent chunked, and strong ETags become weak since the bytes
+// differ from the identity representation.
+func Compress() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			coding, newEncoder := negotiateEncoding(req.Headers.Values("Accept-Encoding"))
+			w.SetEncoder(func(status response.StatusCode, h *headers.Headers) (string, func(io.Writer) io.WriteCloser) {
+				if !compressible(h) {
+					return "", nil
+				}
+				addVary(h, "Accept-Encoding")
+				if coding == "" || !worthCompressing(status, h) {
+					return "", nil
+				}
+				if etag, ok := h.Get("ETag"); ok && strings.HasPrefix(etag, `"`) {
+					h.Set("ETag", "W/"+etag)
+				}
+				return coding, newEncoder
+			})
+			next(w, req)
+		}
+	}
+}
+
+// negotiateEncoding picks the coding with the highest q-value among the
+// Accept-Encoding values, breaking ties by the order of encoders. "*" stands
+// for codings not listed, and a q-value of 0 refuses a coding.
+func negotiateEncoding(values []string) (string, func(io.Writer) io.WriteCloser) {
+	weights := map[string]float64{}
+	for _, value := range values {
+		for element := range strings.SplitSeq(value, ",") {
+			coding, params, _ := strings.Cut(element, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" {
+				continue
+			}
+			if coding == "x-gzip" {
+				coding = "gzip"
+			}
+			weights[coding] = parseQValue(params)
+		}
+	}
+
+	best, bestWeight := -1, 0.0
+	for i, encoder := range encoders {
+		weight, ok := weights[encoder.coding]
+		if !ok {
+			weight = weights["*"]
+		}
+		if weight > bestWeight {
+			best, bestWeight = i, weight
+		}
+	}
+	if best < 0 {
+		return "", nil
+	}
+	return encoders[best].coding, encoders[best].newEncoder
+}
+
+// parseQValue returns the weight set by the q parameter among params,
+// defaulting to 1. Malformed weights count as 0.
+func parseQValue(params string) float64 {
+	for param := range strings.SplitSeq(params, ";") {
+		name, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || weight < 0 || weight > 1 {
+			return 0
+		}
+		return weight
+	}
+	return 1
+}
+
+func compressible(h *headers.Headers) bool {
+	contentType, ok := h.Get("Content-Type")
+	if !ok {
+		return false
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		compressibleTypes[mediaType]
+}
+
+func worthCompressing(status response.StatusCode, h *headers.Headers) bool {
+	if status == response.StatusCodePartialContent {
+		return false
+	}
+	if _, ok := h.Get("Content-Encoding"); ok {
+		return false
+	}
+	if _, ok := h.Get("Content-Range"); ok {
+		return false
+	}
+	if value, ok := h.Get("Cache-Control"); ok && strings.Contains(strings.ToLower(value), "no-transform") {
+		return false
+	}
+	if value, ok := h.Get("Content-Length"); ok {
+		if contentLength, err := strconv.Atoi(value); err == nil && contentLength < COMPRESS_MIN_LENGTH {
+			return false
+		}
+	}
+	return true
+}
+
+// addVary adds field to the Vary header unless it is already listed.
+func addVary(h *headers.Headers, field string) {
+	value, ok := h.Get("Vary")
+	if !ok || strings.TrimSpace(value) == "" {
+		h.Set("Vary", field)
+		return
+	}
+	for listed := range strings.SplitSeq(value, ",") {
+		listed = strings.TrimSpace(listed)
+		if listed == "*" || strings.EqualFold(listed, field) {
+			return
+		}
+	}
+	h.Set("Vary", value+", "+field)
+}
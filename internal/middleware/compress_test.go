@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/testutil"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var page = strings.Repeat("<p>compress me</p>\n", 200)
+
+func sendBody(contentType, body string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("Content-Type", contentType)
+		h.Set("ETag", `"v1"`)
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteBody([]byte(body))
+	}
+}
+
+func compressed(t *testing.T, handler testutil.Handler, acceptEncoding string) (*http.Response, string) {
+	t.Helper()
+	headerLines := []string{}
+	if acceptEncoding != "" {
+		headerLines = append(headerLines, "Accept-Encoding: "+acceptEncoding)
+	}
+	out := testutil.Serve(t, Compress()(handler), testutil.RawRequest("GET", "/", headerLines...))
+
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(out)), nil)
+	require.NoError(t, err)
+	var body io.Reader = resp.Body
+	switch resp.Header.Get("Content-Encoding") {
+	case "gzip":
+		body, err = gzip.NewReader(resp.Body)
+		require.NoError(t, err)
+	case "deflate":
+		body, err = zlib.NewReader(resp.Body)
+		require.NoError(t, err)
+	}
+	decoded, err := io.ReadAll(body)
+	require.NoError(t, err)
+	return resp, string(decoded)
+}
+
+func TestCompressGzip(t *testing.T) {
+	resp, body := compressed(t, sendBody("text/html", page), "gzip, deflate")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, `W/"v1"`, resp.Header.Get("ETag"))
+	assert.Equal(t, page, body)
+}
+
+func TestCompressNegotiation(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		coding         string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"br", ""},
+		{"gzip", "gzip"},
+		{"x-gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"GZIP;Q=0.8, deflate;q=0.9", "deflate"},
+		{"gzip;q=0", ""},
+		{"*", "gzip"},
+		{"*;q=0.3, gzip;q=0", "deflate"},
+		{"gzip;q=bogus, deflate;q=0.1", "deflate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			coding, newEncoder := negotiateEncoding([]string{tt.acceptEncoding})
+			assert.Equal(t, tt.coding, coding)
+			assert.Equal(t, tt.coding != "", newEncoder != nil)
+		})
+	}
+}
+
+func TestCompressSkips(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(w *response.Writer, req *request.Request)
+		vary    string
+	}{
+		{"small body", sendBody("text/plain", "tiny"), "Accept-Encoding"},
+		{"video", sendBody("video/mp4", page), ""},
+		{"no content type", func(w *response.Writer, req *request.Request) {
+			h := response.GetDefaultHeaders(len(page))
+			h.Del("Content-Type")
+			_ = w.WriteStatusLine(response.StatusCodeOK)
+			_ = w.WriteHeaders(h)
+			_, _ = w.WriteBody([]byte(page))
+		}, ""},
+		{"already encoded", func(w *response.Writer, req *request.Request) {
+			h := response.GetDefaultHeaders(len(page))
+			h.Set("Content-Encoding", "identity")
+			_ = w.WriteStatusLine(response.StatusCodeOK)
+			_ = w.WriteHeaders(h)
+			_, _ = w.WriteBody([]byte(page))
+		}, "Accept-Encoding"},
+		{"partial content", func(w *response.Writer, req *request.Request) {
+			h := response.GetDefaultHeaders(len(page))
+			h.Set("Content-Range", "bytes 0-"+strconv.Itoa(len(page)-1)+"/10000")
+			_ = w.WriteStatusLine(response.StatusCodePartialContent)
+			_ = w.WriteHeaders(h)
+			_, _ = w.WriteBody([]byte(page))
+		}, "Accept-Encoding"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := compressed(t, tt.handler, "gzip")
+			assert.NotEqual(t, "gzip", resp.Header.Get("Content-Encoding"))
+			assert.Equal(t, tt.vary, resp.Header.Get("Vary"))
+			assert.NotEqual(t, int64(-1), resp.ContentLength)
+		})
+	}
+}
+
+func TestCompressChunkedWithTrailers(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("Content-Type", "application/json")
+		h.Set("Vary", "Origin")
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Count")
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteChunkedBody([]byte(`{"a":`))
+		_, _ = w.WriteChunkedBody([]byte(`1}`))
+		_, _ = w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Count", "2")
+		_ = w.WriteTrailers(trailers)
+	}
+
+	resp, body := compressed(t, handler, "deflate")
+	assert.Equal(t, "deflate", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Origin, Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, `{"a":1}`, body)
+	assert.Equal(t, "2", resp.Trailer.Get("X-Count"))
+}
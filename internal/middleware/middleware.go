@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+const REQUEST_ID_HEADER = "X-Request-Id"
+
+// AccessLog logs one line per request with the client, request line, status,
+// body size and duration. A nil logger uses the standard logger.
+func AccessLog(logger *log.Logger) server.Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+			logger.Printf("%s \"%s %s HTTP/%s\" %d %d %v",
+				req.RemoteAddr,
+				req.RequestLine.Method,
+				req.RequestLine.RequestTarget,
+				req.RequestLine.HttpVersion,
+				w.Status(),
+				w.BodyBytes(),
+				time.Since(start),
+			)
+		}
+	}
+}
+
+// Recover turns a panicking handler into a 500 response. If the status line
+// was already sent the response is left incomplete, which makes the server
+// drop the connection.
+func Recover() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			defer func() {
+				if err := recover(); err != nil {
+					log.Printf("panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, err, debug.Stack())
+					if w.Status() == 0 {
+						server.HandlerError{
+							Status:  response.StatusCodeInternalServerError,
+							Message: "Internal Server Error",
+						}.WriteError(w)
+					}
+				}
+			}()
+			next(w, req)
+		}
+	}
+}
+
+// RequestID tags each request with an X-Request-Id, keeping the one sent by
+// the client if any, and echoes it in the response.
+func RequestID() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			id, ok := req.Headers.Get(REQUEST_ID_HEADER)
+			if !ok || id == "" {
+				id = newRequestID()
+				req.Headers.Set(REQUEST_ID_HEADER, id)
+			}
+			w.SetHeader(REQUEST_ID_HEADER, id)
+			next(w, req)
+		}
+	}
+}
+
+// Timing reports how long each request took to handle.
+func Timing(report func(req *request.Request, duration time.Duration)) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			defer func() {
+				report(req, time.Since(start))
+			}()
+			next(w, req)
+		}
+	}
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
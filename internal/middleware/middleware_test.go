@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"httpfromtcp/internal/testutil"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var ok = testutil.Named("ok")
+
+func TestChainOrder(t *testing.T) {
+	order := []string{}
+	tag := func(name string) server.Middleware {
+		return func(next server.Handler) server.Handler {
+			return func(w *response.Writer, req *request.Request) {
+				order = append(order, name)
+				next(w, req)
+			}
+		}
+	}
+
+	testutil.Serve(t, server.Chain(ok, tag("first"), tag("second")), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, []string{"first", "second"}, order)
+}
+
+func TestAccessLog(t *testing.T) {
+	var logs bytes.Buffer
+	handler := server.Chain(ok, AccessLog(log.New(&logs, "", 0)))
+
+	testutil.Serve(t, handler, "GET /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, logs.String(), "\"GET /coffee HTTP/1.1\" 200 2")
+}
+
+func TestRecover(t *testing.T) {
+	handler := server.Chain(func(w *response.Writer, req *request.Request) {
+		panic("boom")
+	}, Recover())
+
+	out := testutil.Serve(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"))
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := server.Chain(func(w *response.Writer, req *request.Request) {
+		seen, _ = req.Headers.Get(REQUEST_ID_HEADER)
+		ok(w, req)
+	}, RequestID())
+
+	out := testutil.Serve(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Len(t, seen, 32)
+	assert.Contains(t, out, "X-Request-Id: "+seen+"\r\n")
+
+	out = testutil.Serve(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-Id: abc\r\n\r\n")
+	assert.Equal(t, "abc", seen)
+	assert.Contains(t, out, "X-Request-Id: abc\r\n")
+}
+
+func TestTiming(t *testing.T) {
+	var elapsed time.Duration
+	handler := server.Chain(func(w *response.Writer, req *request.Request) {
+		time.Sleep(time.Millisecond)
+		ok(w, req)
+	}, Timing(func(req *request.Request, duration time.Duration) {
+		elapsed = duration
+	}))
+
+	testutil.Serve(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.GreaterOrEqual(t, elapsed, time.Millisecond)
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DEFAULT_MAX_FAILS = 3
+const DEFAULT_FAIL_TIMEOUT = 30 * time.Second
+
+// Backend is one upstream server of a Proxy.
+type Backend struct {
+	url    *url.URL
+	active atomic.Int64
+
+	mu sync.Mutex
+	// down is set by active health checks
+	down bool
+	// failures counts consecutive failed requests; reaching the proxy's
+	// MaxFails ejects the backend until ejectedUntil
+	failures     int
+	ejectedUntil time.Time
+}
+
+func (b *Backend) URL() *url.URL {
+	return b.url
+}
+
+// ActiveRequests returns the number of requests in flight to the backend.
+func (b *Backend) ActiveRequests() int64 {
+	return b.active.Load()
+}
+
+// Available reports whether the backend passed its last health check and is
+// not ejected for failing requests.
+func (b *Backend) Available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.down && !time.Now().Before(b.ejectedUntil)
+}
+
+func (b *Backend) recordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+// recordFailure ejects the backend for failTimeout once it has failed
+// maxFails requests in a row.
+func (b *Backend) recordFailure(maxFails int, failTimeout time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if maxFails > 0 && b.failures >= maxFails {
+		b.ejectedUntil = time.Now().Add(failTimeout)
+		b.failures = 0
+	}
+}
+
+func (b *Backend) setHealthy(healthy bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.down = !healthy
+	if healthy {
+		// a passing check is fresher news than earlier failures
+		b.failures = 0
+		b.ejectedUntil = time.Time{}
+	}
+}
+
+// HealthCheck sends GET path to every backend each interval until ctx is
+// done, taking backends that fail to answer with a 2xx or 3xx status out of
+// rotation until they pass again. Run it in its own goroutine.
+func (p *Proxy) HealthCheck(ctx context.Context, path string, interval time.Duration) {
+	client := &http.Client{
+		Transport: p.Transport,
+		Timeout:   interval,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, backend := range p.backends {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				healthy := checkHealth(ctx, client, backend, path)
+				if ctx.Err() == nil {
+					backend.setHealthy(healthy)
+				}
+			}()
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func checkHealth(ctx context.Context, client *http.Client, backend *Backend, path string) bool {
+	target := *backend.url
+	target.Path = joinPath(backend.url.Path, path)
+	target.RawPath = ""
+
+	req, err := http.NewRequestWithContext(ctx, "GET", target.String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
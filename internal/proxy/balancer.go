@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"httpfromtcp/internal/request"
+	"net"
+	"sync/atomic"
+)
+
+// Balancer picks the backend for a request among candidates, which are the
+// available backends that have not failed the request yet. candidates is
+// never empty.
+type Balancer interface {
+	Pick(req *request.Request, candidates []*Backend) *Backend
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+// RoundRobin cycles through the backends in turn.
+func RoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+func (rr *roundRobin) Pick(req *request.Request, candidates []*Backend) *Backend {
+	return candidates[(rr.next.Add(1)-1)%uint64(len(candidates))]
+}
+
+type leastConnections struct{}
+
+// LeastConnections picks the backend with the fewest requests in flight,
+// preferring earlier backends on ties.
+func LeastConnections() Balancer {
+	return leastConnections{}
+}
+
+func (leastConnections) Pick(req *request.Request, candidates []*Backend) *Backend {
+	best := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if candidate.ActiveRequests() < best.ActiveRequests() {
+			best = candidate
+		}
+	}
+	return best
+}
+
+type consistentHash struct {
+	header string
+}
+
+// ConsistentHash sends requests with the same key to the same backend, where
+// the key is the value of header, or the client IP when header is empty or
+// missing from the request. It uses rendezvous hashing, so only the keys of
+// a backend that goes away move, and they spread over the others.
+func ConsistentHash(header string) Balancer {
+	return consistentHash{header: header}
+}
+
+func (ch consistentHash) Pick(req *request.Request, candidates []*Backend) *Backend {
+	key, ok := "", false
+	if ch.header != "" {
+		key, ok = req.Headers.Get(ch.header)
+	}
+	if !ok {
+		key = req.RemoteAddr
+		if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+			key = host
+		}
+	}
+
+	var best *Backend
+	var bestScore uint64
+	for _, candidate := range candidates {
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(candidate.url.String()))
+		_, _ = hash.Write([]byte{0})
+		_, _ = hash.Write([]byte(key))
+		if score := mix(hash.Sum64()); best == nil || score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	return best
+}
+
+// mix is the splitmix64 finalizer. FNV barely carries the last bytes of the
+// key into the high bits that decide which score is largest, so the scores
+// are mixed before they are compared.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/testutil"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func namedUpstream(t *testing.T, name string) string {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, name)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream.URL
+}
+
+func deadUpstream(t *testing.T) string {
+	t.Helper()
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+	return upstream.URL
+}
+
+func TestRoundRobin(t *testing.T) {
+	p, err := New(namedUpstream(t, "a"), namedUpstream(t, "b"), namedUpstream(t, "c"))
+	require.NoError(t, err)
+
+	var order []string
+	for range 6 {
+		order = append(order, testutil.Body(testutil.Serve(t, p.Serve, testutil.RawRequest("GET", "/"))))
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, order)
+}
+
+func TestLeastConnections(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = io.WriteString(w, "slow")
+	}))
+	t.Cleanup(slow.Close)
+
+	p, err := New(slow.URL, namedUpstream(t, "fast"))
+	require.NoError(t, err)
+	p.Balancer = LeastConnections()
+
+	done := make(chan string)
+	go func() { done <- testutil.Body(testutil.Serve(t, p.Serve, testutil.RawRequest("GET", "/"))) }()
+	<-started
+
+	assert.Equal(t, int64(1), p.Backends()[0].ActiveRequests())
+	for range 3 {
+		assert.Equal(t, "fast", testutil.Body(testutil.Serve(t, p.Serve, testutil.RawRequest("GET", "/"))))
+	}
+	close(release)
+	assert.Equal(t, "slow", <-done)
+	assert.Equal(t, int64(0), p.Backends()[0].ActiveRequests())
+}
+
+func TestConsistentHash(t *testing.T) {
+	p, err := New(namedUpstream(t, "a"), namedUpstream(t, "b"), namedUpstream(t, "c"))
+	require.NoError(t, err)
+	p.Balancer = ConsistentHash("X-User")
+
+	seen := map[string]string{}
+	for _, user := range []string{"ann", "bob", "cid", "dee", "eve", "fay", "gus", "hal", "ida", "jon", "kim", "lee", "max", "ned", "ola", "pam"} {
+		raw := "GET / HTTP/1.1\r\nHost: localhost\r\nX-User: " + user + "\r\n\r\n"
+		seen[user] = testutil.Body(testutil.Serve(t, p.Serve, raw))
+		for range 3 {
+			assert.Equal(t, seen[user], testutil.Body(testutil.Serve(t, p.Serve, raw)), user)
+		}
+	}
+	assert.Greater(t, len(uniqueValues(seen)), 1)
+
+	// without the header, the client IP is the key
+	first := testutil.Body(testutil.Serve(t, p.Serve, testutil.RawRequest("GET", "/")))
+	assert.Equal(t, first, testutil.Body(testutil.Serve(t, p.Serve, testutil.RawRequest("GET", "/"))))
+
+	// keys only move off a backend that goes away
+	gone := p.Backends()[0]
+	gone.setHealthy(false)
+	for user, name := range seen {
+		raw := "GET / HTTP/1.1\r\nHost: localhost\r\nX-User: " + user + "\r\n\r\n"
+		if name != "a" {
+			assert.Equal(t, name, testutil.Body(testutil.Serve(t, p.Serve, raw)), user)
+		} else {
+			assert.NotEqual(t, "a", testutil.Body(testutil.Serve(t, p.Serve, raw)), user)
+		}
+	}
+}
+
+func TestConsistentHashSpreadsKeys(t *testing.T) {
+	var backends []*Backend
+	for _, upstream := range []string{"http://127.0.0.1:8001", "http://127.0.0.1:8002", "http://127.0.0.1:8003"} {
+		target, err := url.Parse(upstream)
+		require.NoError(t, err)
+		backends = append(backends, &Backend{url: target})
+	}
+
+	balancer := ConsistentHash("X-User")
+	counts := map[*Backend]int{}
+	for i := range 3_000 {
+		req := &request.Request{Headers: headers.NewHeaders()}
+		req.Headers.Set("X-User", fmt.Sprintf("user-%d", i))
+		counts[balancer.Pick(req, backends)]++
+	}
+	for _, backend := range backends {
+		assert.InDelta(t, 1_000, counts[backend], 150, backend.URL().String())
+	}
+}
+
+func uniqueValues(m map[string]string) map[string]bool {
+	unique := map[string]bool{}
+	for _, value := range m {
+		unique[value] = true
+	}
+	return unique
+}
+
+func TestRetriesIdempotentRequests(t *testing.T) {
+	p, err := New(deadUpstream(t), namedUpstream(t, "live"))
+	require.NoError(t, err)
+	p.MaxFails = 1
+
+	out := testutil.Serve(t, p.Serve, testutil.RawRequest("GET", "/"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, "live", testutil.Body(out))
+	assert.False(t, p.Backends()[0].Available(), "the dead backend should be ejected")
+}
+
+func TestDoesNotRetryUnsafeRequests(t *testing.T) {
+	p, err := New(deadUpstream(t), namedUpstream(t, "live"))
+	require.NoError(t, err)
+
+	out := testutil.Serve(t, p.Serve, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhi")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 502 Bad Gateway\r\n"))
+}
+
+func TestPassiveEjection(t *testing.T) {
+	p, err := New(deadUpstream(t))
+	require.NoError(t, err)
+	p.MaxFails = 2
+	p.FailTimeout = time.Minute
+	backend := p.Backends()[0]
+
+	testutil.Serve(t, p.Serve, testutil.RawRequest("GET", "/"))
+	assert.True(t, backend.Available())
+	testutil.Serve(t, p.Serve, testutil.RawRequest("GET", "/"))
+	assert.False(t, backend.Available())
+
+	out := testutil.Serve(t, p.Serve, testutil.RawRequest("GET", "/"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"))
+}
+
+func TestPassiveEjectionExpires(t *testing.T) {
+	p, err := New(deadUpstream(t))
+	require.NoError(t, err)
+	p.MaxFails = 1
+	p.FailTimeout = 20 * time.Millisecond
+	backend := p.Backends()[0]
+
+	testutil.Serve(t, p.Serve, testutil.RawRequest("GET", "/"))
+	require.False(t, backend.ejectedUntil.IsZero(), "the backend should have been ejected")
+	assert.Eventually(t, backend.Available, time.Second, 5*time.Millisecond)
+}
+
+func TestActiveHealthCheck(t *testing.T) {
+	var healthy atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" && !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+	backend := p.Backends()[0]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go p.HealthCheck(ctx, "/healthz", 5*time.Millisecond)
+
+	assert.Eventually(t, func() bool { return !backend.Available() }, time.Second, time.Millisecond)
+	healthy.Store(true)
+	assert.Eventually(t, backend.Available, time.Second, time.Millisecond)
+}
@@ -0,0 +1,469 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const BUFFER_SIZE = 32 * 1_024
+const DEFAULT_TIMEOUT = 60 * time.Second
+
+// Trailers added by Proxy.ChecksumTrailers.
+const DIGEST_TRAILER = "X-Content-Sha256"
+const LENGTH_TRAILER = "X-Content-Length"
+
+// hopByHopHeaders only apply to a single connection, RFC 9110 section 7.6.1,
+// so they are never forwarded.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Proxy forwards requests to a pool of upstream servers and streams their
+// responses back. Proxy.Serve is a server.Handler.
+type Proxy struct {
+	backends []*Backend
+	// Balancer spreads requests over the backends. It defaults to
+	// RoundRobin.
+	Balancer Balancer
+	// MaxFails consecutive failed requests eject a backend for FailTimeout.
+	// Connection errors and 502, 503 and 504 responses count as failures.
+	MaxFails    int
+	FailTimeout time.Duration
+	// ChecksumTrailers sends every response body chunked, followed by
+	// DIGEST_TRAILER and LENGTH_TRAILER trailers computed as it streams.
+	ChecksumTrailers bool
+	// StripPrefix is removed from the request path before it is appended to
+	// the upstream path.
+	StripPrefix string
+	// Timeout bounds the wait for an upstream's response headers, after
+	// which the client gets 504. Zero means no limit.
+	Timeout time.Duration
+	// Transport sends requests upstream. It defaults to a clone of
+	// http.DefaultTransport that leaves response bodies compressed.
+	Transport http.RoundTripper
+}
+
+// New returns a Proxy for one or more upstream URLs such as
+// "http://127.0.0.1:8080" or "https://api.example.com/v1".
+func New(upstreams ...string) (*Proxy, error) {
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("no upstream given")
+	}
+	backends := make([]*Backend, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		target, err := url.Parse(upstream)
+		if err != nil {
+			return nil, err
+		}
+		if (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return nil, fmt.Errorf("invalid upstream %q: need an http or https URL with a host", upstream)
+		}
+		backends = append(backends, &Backend{url: target})
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableCompression = true
+	return &Proxy{
+		backends:    backends,
+		Balancer:    RoundRobin(),
+		MaxFails:    DEFAULT_MAX_FAILS,
+		FailTimeout: DEFAULT_FAIL_TIMEOUT,
+		Timeout:     DEFAULT_TIMEOUT,
+		Transport:   transport,
+	}, nil
+}
+
+// Backends returns the upstream servers in the order they were given.
+func (p *Proxy) Backends() []*Backend {
+	return p.backends
+}
+
+// Serve forwards req to a backend picked by the Balancer. Idempotent
+// requests without a body that fail to reach a backend are retried once on
+// each of the other available backends.
+func (p *Proxy) Serve(w *response.Writer, req *request.Request) {
+	if req.RequestLine.TargetForm == request.TargetAuthorityForm {
+		writeError(w, response.StatusCodeNotImplemented)
+		return
+	}
+
+	var tried []*Backend
+	for {
+		candidates := p.candidates(tried)
+		if len(candidates) == 0 {
+			writeError(w, response.StatusCodeServiceUnavailable)
+			return
+		}
+		backend := p.Balancer.Pick(req, candidates)
+		tried = append(tried, backend)
+
+		err := p.forward(w, req, backend)
+		if err == nil {
+			return
+		}
+		log.Printf("error proxying to %s: %v", backend.url.Host, err)
+		if !retryable(req) || len(p.candidates(tried)) == 0 {
+			writeError(w, upstreamErrorStatus(err))
+			return
+		}
+	}
+}
+
+// forward sends req to backend and copies the response to w. It only
+// returns an error when nothing was written, so the request can be retried.
+func (p *Proxy) forward(w *response.Writer, req *request.Request, backend *Backend) error {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	outreq, err := p.newUpstreamRequest(ctx, req, backend)
+	if err != nil {
+		return err
+	}
+
+	if outreq.Body != nil {
+		// the server drains the body once the handler returns, so the
+		// transport must be done with it first
+		defer outreq.Body.Close()
+	}
+	backend.active.Add(1)
+	defer backend.active.Add(-1)
+	resp, err := p.roundTrip(outreq, cancel)
+	if err != nil {
+		backend.recordFailure(p.MaxFails, p.FailTimeout)
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		backend.recordFailure(p.MaxFails, p.FailTimeout)
+	default:
+		backend.recordSuccess()
+	}
+	p.copyResponse(w, resp)
+	return nil
+}
+
+var errUpstreamTimeout = errors.New("upstream response headers timed out")
+
+// roundTrip sends outreq, canceling it through cancel if the response
+// headers take longer than p.Timeout.
+func (p *Proxy) roundTrip(outreq *http.Request, cancel context.CancelCauseFunc) (*http.Response, error) {
+	if p.Timeout <= 0 {
+		return p.Transport.RoundTrip(outreq)
+	}
+	timer := time.AfterFunc(p.Timeout, func() { cancel(errUpstreamTimeout) })
+	resp, err := p.Transport.RoundTrip(outreq)
+	if !timer.Stop() {
+		// the request was canceled, or is about to fail mid-body
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return nil, fmt.Errorf("%w after %v", errUpstreamTimeout, p.Timeout)
+	}
+	return resp, err
+}
+
+// candidates returns the available backends not in tried.
+func (p *Proxy) candidates(tried []*Backend) []*Backend {
+	var candidates []*Backend
+	for _, backend := range p.backends {
+		if backend.Available() && !slices.Contains(tried, backend) {
+			candidates = append(candidates, backend)
+		}
+	}
+	return candidates
+}
+
+// retryable reports whether req can safely be sent again: its method is
+// idempotent, RFC 9110 section 9.2.2, and it has no body that was already
+// consumed.
+func retryable(req *request.Request) bool {
+	switch req.RequestLine.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+	default:
+		return false
+	}
+	return req.ContentLength == 0
+}
+
+// newUpstreamRequest builds the request to send to backend, streaming the
+// client's body and forwarding its end-to-end headers.
+func (p *Proxy) newUpstreamRequest(ctx context.Context, req *request.Request, backend *Backend) (*http.Request, error) {
+	target := *backend.url
+	rawPath := strings.TrimPrefix(req.RequestLine.RawPath, p.StripPrefix)
+	target.RawPath = joinPath(backend.url.EscapedPath(), rawPath)
+	path, err := url.PathUnescape(target.RawPath)
+	if err != nil {
+		return nil, err
+	}
+	target.Path = path
+	target.RawQuery = req.RequestLine.RawQuery
+
+	var body io.Reader
+	if req.ContentLength != 0 {
+		body = req.BodyReader
+	}
+
+	outreq, err := http.NewRequestWithContext(ctx, req.RequestLine.Method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	outreq.ContentLength = req.ContentLength
+	if body != nil {
+		outreq.Body = &upstreamBody{body: body}
+	}
+
+	for key, value := range endToEnd(req.Headers).All() {
+		if strings.EqualFold(key, "Host") {
+			continue
+		}
+		outreq.Header.Add(key, value)
+	}
+	if _, ok := outreq.Header["User-Agent"]; !ok {
+		// keep the transport from adding its own
+		outreq.Header.Set("User-Agent", "")
+	}
+	addForwardedHeaders(outreq, req)
+	return outreq, nil
+}
+
+var errUpstreamBodyClosed = errors.New("request body already handed back to the server")
+
+// upstreamBody lends the client's body to the transport, which may still be
+// reading it from its own goroutine after RoundTrip returned, for instance
+// when the upstream answered early. Close waits for a read in progress and
+// fails the later ones, so the body is never read from two goroutines.
+type upstreamBody struct {
+	mu     sync.Mutex
+	body   io.Reader
+	closed bool
+}
+
+func (b *upstreamBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, errUpstreamBodyClosed
+	}
+	return b.body.Read(p)
+}
+
+func (b *upstreamBody) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
+
+// addForwardedHeaders records the client and the host it asked for, both in
+// the de facto X-Forwarded-* headers and in Forwarded from RFC 7239.
+func addForwardedHeaders(outreq *http.Request, req *request.Request) {
+	clientIP := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		clientIP = host
+	}
+	if clientIP == "" {
+		return
+	}
+
+	if prior := outreq.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+		outreq.Header.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+clientIP)
+	} else {
+		outreq.Header.Set("X-Forwarded-For", clientIP)
+	}
+	if req.Host.Name != "" {
+		outreq.Header.Set("X-Forwarded-Host", req.Host.String())
+	}
+
+	forwarded := "for=" + forwardedNode(clientIP)
+	if req.Host.Name != "" {
+		forwarded += fmt.Sprintf(";host=%q", req.Host.String())
+	}
+	if prior := outreq.Header.Values("Forwarded"); len(prior) > 0 {
+		forwarded = strings.Join(prior, ", ") + ", " + forwarded
+	}
+	outreq.Header.Set("Forwarded", forwarded)
+}
+
+// forwardedNode quotes IPv6 addresses, which RFC 7239 requires in brackets.
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return fmt.Sprintf(`"[%s]"`, ip)
+	}
+	return ip
+}
+
+// copyResponse passes the upstream status, headers and body to the client.
+// A body of known length keeps its Content-Length; any other is re-chunked
+// as it arrives, followed by the upstream trailers.
+func (p *Proxy) copyResponse(w *response.Writer, resp *http.Response) {
+	h := headers.NewHeaders()
+	for _, key := range sortedKeys(resp.Header) {
+		for _, value := range resp.Header[key] {
+			h.Add(key, value)
+		}
+	}
+	h = endToEnd(h)
+
+	// only trailers announced before the body can be passed on
+	var trailerNames []string
+	for _, key := range sortedKeys(resp.Trailer) {
+		if response.ValidTrailerField(key) {
+			trailerNames = append(trailerNames, key)
+		}
+	}
+	chunked := bodyAllowed(resp) && (resp.ContentLength < 0 || p.ChecksumTrailers)
+	if chunked {
+		h.Del("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+		if len(trailerNames) > 0 {
+			h.Set("Trailer", strings.Join(trailerNames, ", "))
+		}
+	} else if resp.ContentLength >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+
+	reason, found := strings.CutPrefix(resp.Status, strconv.Itoa(resp.StatusCode)+" ")
+	if !found {
+		reason = response.StatusText(response.StatusCode(resp.StatusCode))
+	}
+	if err := w.WriteStatusLineWithReason(response.StatusCode(resp.StatusCode), reason); err != nil {
+		log.Printf("error writing status line: %v", err)
+		return
+	}
+
+	write := w.WriteBody
+	var digest *response.DigestWriter
+	var err error
+	switch {
+	case chunked && p.ChecksumTrailers:
+		digest = response.NewDigestWriter(w, sha256.New(), DIGEST_TRAILER, LENGTH_TRAILER)
+		err = digest.WriteHeaders(h)
+		write = digest.Write
+	case chunked:
+		err = w.WriteHeaders(h)
+		write = w.WriteChunkedBody
+	default:
+		err = w.WriteHeaders(h)
+	}
+	if err != nil {
+		log.Printf("error writing headers: %v", err)
+		return
+	}
+
+	buffer := make([]byte, BUFFER_SIZE)
+	for {
+		n, err := resp.Body.Read(buffer)
+		if n > 0 {
+			if _, writeErr := write(buffer[:n]); writeErr != nil {
+				log.Printf("error writing body: %v", writeErr)
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("error reading upstream body: %v", err)
+			return
+		}
+	}
+	if !chunked {
+		return
+	}
+
+	trailers := headers.NewHeaders()
+	for _, key := range trailerNames {
+		for _, value := range resp.Trailer[key] {
+			trailers.Add(key, value)
+		}
+	}
+	if digest != nil {
+		digest.Trailers = trailers
+		err = digest.Close()
+	} else if _, err = w.WriteChunkedBodyDone(); err == nil {
+		err = w.WriteTrailers(trailers)
+	}
+	if err != nil {
+		log.Printf("error writing trailers: %v", err)
+	}
+}
+
+// endToEnd returns a copy of h without hop-by-hop headers, including those
+// named by Connection.
+func endToEnd(h *headers.Headers) *headers.Headers {
+	forwarded := h.Clone()
+	for _, value := range h.Values("Connection") {
+		for option := range strings.SplitSeq(value, ",") {
+			if option = strings.TrimSpace(option); option != "" {
+				forwarded.Del(option)
+			}
+		}
+	}
+	for _, key := range hopByHopHeaders {
+		forwarded.Del(key)
+	}
+	return forwarded
+}
+
+// bodyAllowed reports whether a response can carry a body at all.
+func bodyAllowed(resp *http.Response) bool {
+	status := response.StatusCode(resp.StatusCode)
+	return resp.Request.Method != "HEAD" && !status.IsInformational() &&
+		status != response.StatusCodeNoContent && status != response.StatusCodeNotModified
+}
+
+func upstreamErrorStatus(err error) response.StatusCode {
+	var netErr net.Error
+	if errors.Is(err, errUpstreamTimeout) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return response.StatusCodeGatewayTimeout
+	}
+	return response.StatusCodeBadGateway
+}
+
+func joinPath(base, path string) string {
+	if path == "" {
+		path = "/"
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+func sortedKeys(h http.Header) []string {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func writeError(w *response.Writer, status response.StatusCode) {
+	server.HandlerError{
+		Status:  status,
+		Message: response.StatusText(status),
+	}.WriteError(w)
+}
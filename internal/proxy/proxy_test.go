@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"httpfromtcp/internal/testutil"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startUpstream(t *testing.T, handler http.HandlerFunc) *Proxy {
+	t.Helper()
+	upstream := httptest.NewServer(handler)
+	t.Cleanup(upstream.Close)
+
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+	return p
+}
+
+func TestForwardsRequest(t *testing.T) {
+	var received *http.Request
+	var body []byte
+	p := startUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	})
+	p.StripPrefix = "/api"
+
+	testutil.Serve(t, p.Serve, "POST /api/items%2F1?sort=asc HTTP/1.1\r\n"+
+		"Host: public.test\r\n"+
+		"Content-Length: 5\r\n"+
+		"Connection: keep-alive, X-Hop\r\n"+
+		"X-Hop: secret\r\n"+
+		"Keep-Alive: timeout=5\r\n"+
+		"X-Forwarded-For: 198.51.100.7\r\n"+
+		"X-Custom: kept\r\n"+
+		"\r\n"+
+		"hello")
+
+	require.NotNil(t, received)
+	assert.Equal(t, "POST", received.Method)
+	assert.Equal(t, "/items%2F1", received.URL.EscapedPath())
+	assert.Equal(t, "sort=asc", received.URL.RawQuery)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, "kept", received.Header.Get("X-Custom"))
+	assert.Empty(t, received.Header.Get("X-Hop"))
+	assert.Empty(t, received.Header.Get("Keep-Alive"))
+	assert.Empty(t, received.Header.Get("User-Agent"))
+	assert.Equal(t, "198.51.100.7, 192.0.2.1", received.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "public.test", received.Header.Get("X-Forwarded-Host"))
+	assert.Equal(t, `for=192.0.2.1;host="public.test"`, received.Header.Get("Forwarded"))
+}
+
+func TestForwardsChunkedRequestBody(t *testing.T) {
+	var body []byte
+	p := startUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	})
+
+	testutil.Serve(t, p.Serve, "PUT / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n")
+	assert.Equal(t, "hello world", string(body))
+}
+
+func TestPassesResponseThrough(t *testing.T) {
+	p := startUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.Header().Set("Connection", "X-Internal")
+		w.Header().Set("X-Internal", "hidden")
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = io.WriteString(w, `{"ok":false}`)
+	})
+
+	out := testutil.Serve(t, p.Serve, testutil.RawRequest("GET", "/"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 418 I'm a teapot\r\n"))
+	assert.Contains(t, out, "Content-Type: application/json\r\n")
+	assert.Contains(t, out, "Set-Cookie: a=1\r\nSet-Cookie: b=2\r\n")
+	assert.Contains(t, out, "Content-Length: 12\r\n")
+	assert.NotContains(t, out, "X-Internal")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n{\"ok\":false}"))
+}
+
+// watchWriter closes seen once the output contains want.
+type watchWriter struct {
+	bytes.Buffer
+	want string
+	seen chan struct{}
+}
+
+func (w *watchWriter) Write(p []byte) (int, error) {
+	n, err := w.Buffer.Write(p)
+	if w.want != "" && strings.Contains(w.String(), w.want) {
+		w.want = ""
+		close(w.seen)
+	}
+	return n, err
+}
+
+func TestStreamsChunkedResponse(t *testing.T) {
+	out := &watchWriter{want: "6\r\nfirst \r\n", seen: make(chan struct{})}
+	p := startUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		_, _ = io.WriteString(w, "first ")
+		w.(http.Flusher).Flush()
+		// the rest only comes once the client got the first chunk
+		select {
+		case <-out.seen:
+		case <-time.After(5 * time.Second):
+			t.Error("the first chunk was not passed on before the upstream finished")
+		}
+		_, _ = io.WriteString(w, "second")
+		w.Header().Set("X-Checksum", "abc")
+	})
+
+	req := testutil.ParseRequest(t, testutil.RawRequest("GET", "/"))
+	w := response.NewWriter(out)
+	p.Serve(&w, req)
+
+	assert.Contains(t, out.String(), "Transfer-Encoding: chunked\r\n")
+	assert.Contains(t, out.String(), "Trailer: X-Checksum\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "6\r\nsecond\r\n0\r\nX-Checksum: abc\r\n\r\n"))
+}
+
+func TestUpstreamUnavailable(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+	upstream.Close()
+
+	out := testutil.Serve(t, p.Serve, testutil.RawRequest("GET", "/"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 502 Bad Gateway\r\n"))
+}
+
+func TestUpstreamTimeout(t *testing.T) {
+	release := make(chan struct{})
+	p := startUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-body" {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+			_, _ = io.WriteString(w, "late")
+			return
+		}
+		<-release
+	})
+	t.Cleanup(func() { close(release) })
+	p.Timeout = 50 * time.Millisecond
+
+	out := testutil.Serve(t, p.Serve, testutil.RawRequest("GET", "/hung"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 504 Gateway Timeout\r\n"), out)
+
+	// the timeout ends once the headers are in, however long the body takes
+	out = testutil.Serve(t, p.Serve, testutil.RawRequest("GET", "/slow-body"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.True(t, strings.HasSuffix(out, "late\r\n0\r\n\r\n"), out)
+}
+
+func TestNewRejectsInvalidUpstream(t *testing.T) {
+	_, err := New("ftp://example.com")
+	assert.Error(t, err)
+	_, err = New("http://")
+	assert.Error(t, err)
+}
+
+func TestChecksumTrailers(t *testing.T) {
+	p := startUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Upstream, Content-Type")
+		_, _ = io.WriteString(w, "hello world")
+		w.Header().Set("X-Upstream", "yes")
+		w.Header().Set("Content-Type", "text/late")
+	})
+	p.ChecksumTrailers = true
+
+	out := testutil.Serve(t, p.Serve, testutil.RawRequest("GET", "/"))
+	assert.Contains(t, out, "Transfer-Encoding: chunked\r\n")
+	assert.Contains(t, out, "Trailer: X-Upstream, "+DIGEST_TRAILER+", "+LENGTH_TRAILER+"\r\n")
+	assert.NotContains(t, out, "text/late")
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte("hello world")))
+	assert.True(t, strings.HasSuffix(out, "0\r\nX-Upstream: yes\r\n"+DIGEST_TRAILER+": "+sum+"\r\n"+LENGTH_TRAILER+": 11\r\n\r\n"), out)
+}
+
+func TestEarlyUpstreamResponseKeepsConnectionUsable(t *testing.T) {
+	p := startUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			// answer without reading the body
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		_, _ = io.WriteString(w, "second")
+	})
+	s, err := server.Serve(0, p.Serve)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	const size = 4 << 20
+	go func() {
+		_, _ = io.WriteString(conn, "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: "+strconv.Itoa(size)+"\r\n\r\n")
+		_, _ = conn.Write(bytes.Repeat([]byte("x"), size))
+		_, _ = io.WriteString(conn, "GET /next HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	}()
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 413 "), string(out))
+	assert.Contains(t, string(out), "HTTP/1.1 200 OK\r\n")
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\nsecond"), string(out))
+}
+
+func TestForwardsDecodedRequestBody(t *testing.T) {
+	received := make(chan string, 1)
+	p := startUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r.Header.Get("Content-Encoding") + "|" + string(body)
+	})
+	config := server.DefaultConfig(0)
+	config.DecodeRequestBodies = true
+	s, err := server.ServeConfig(config, p.Serve)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, _ = io.WriteString(gz, "hello upstream")
+	require.NoError(t, gz.Close())
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	_, err = io.WriteString(conn, "POST /upload HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n"+
+		"Content-Encoding: gzip\r\nContent-Length: "+strconv.Itoa(compressed.Len())+"\r\n\r\n"+compressed.String())
+	require.NoError(t, err)
+	_, _ = io.ReadAll(conn)
+
+	assert.Equal(t, "|hello upstream", <-received)
+}
@@ -0,0 +1,157 @@
+package request
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// SUPPORTED_CONTENT_ENCODINGS lists the content codings DecodeBody can undo,
+// in the form of an Accept-Encoding value.
+const SUPPORTED_CONTENT_ENCODINGS = "gzip, deflate"
+
+var (
+	ErrUnsupportedContentEncoding = errors.New("unsupported content coding")
+	ErrInvalidEncodedBody         = errors.New("body does not match its Content-Encoding")
+)
+
+// DecodeBody makes the body read through BodyReader, and Body when it is
+// buffered, come out with the codings listed by Content-Encoding undone. The
+// Content-Encoding and Content-Length headers are removed since they no
+// longer describe the body, and ContentLength becomes -1 until a buffered
+// body is decoded. Reading more than maxBytes of decoded body fails
+// with ErrBodyTooLarge; 0 means no limit. It must be called before the body
+// is read, and returns ErrUnsupportedContentEncoding without touching the
+// request when a coding is not gzip or deflate.
+func (r *Request) DecodeBody(maxBytes int64) error {
+	codings, err := contentCodings(r.Headers.Values("Content-Encoding"))
+	if err != nil || len(codings) == 0 {
+		return err
+	}
+	r.Headers.Del("Content-Encoding")
+	r.Headers.Del("Content-Length")
+	if r.ContentLength == 0 {
+		return nil
+	}
+	r.BodyReader = &decodedBody{raw: r.BodyReader, codings: codings, maxBytes: maxBytes}
+	r.ContentLength = -1
+	if r.streaming {
+		return nil
+	}
+
+	body, err := io.ReadAll(r.BodyReader)
+	r.Body = body
+	r.BodyReader = io.NopCloser(bytes.NewReader(body))
+	if err == nil {
+		r.ContentLength = int64(len(body))
+	}
+	return err
+}
+
+// contentCodings returns the codings applied to the body, in the order they
+// were applied, leaving out identity.
+func contentCodings(values []string) ([]string, error) {
+	var codings []string
+	for _, value := range values {
+		for coding := range strings.SplitSeq(value, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			switch coding {
+			case "", "identity":
+			case "gzip", "x-gzip", "deflate":
+				codings = append(codings, coding)
+			default:
+				return nil, fmt.Errorf("%w: %q", ErrUnsupportedContentEncoding, coding)
+			}
+		}
+	}
+	return codings, nil
+}
+
+// decodedBody undoes the content codings of raw as it is read. The
+// decompressors are set up on the first Read, since they start by reading
+// the stream header.
+type decodedBody struct {
+	raw      io.ReadCloser
+	codings  []string
+	maxBytes int64
+
+	decoder io.Reader
+	closers []io.Closer
+	read    int64
+	err     error
+}
+
+func (d *decodedBody) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	if d.decoder == nil {
+		if err := d.init(); err != nil {
+			d.err = err
+			return 0, err
+		}
+	}
+
+	n, err := d.decoder.Read(p)
+	d.read += int64(n)
+	if d.maxBytes > 0 && d.read > d.maxBytes {
+		d.err = fmt.Errorf("%w: decoded body over %d bytes", ErrBodyTooLarge, d.maxBytes)
+		return n - int(d.read-d.maxBytes), d.err
+	}
+	if err != nil && err != io.EOF && !errors.Is(err, ErrBodyTooLarge) && !isReadError(err) {
+		err = fmt.Errorf("%w: %w", ErrInvalidEncodedBody, err)
+	}
+	if err != nil {
+		d.err = err
+	}
+	return n, err
+}
+
+func (d *decodedBody) init() error {
+	var reader io.Reader = d.raw
+	for i := len(d.codings) - 1; i >= 0; i-- {
+		var decoder io.ReadCloser
+		var err error
+		switch d.codings[i] {
+		case "gzip", "x-gzip":
+			decoder, err = gzip.NewReader(reader)
+		case "deflate":
+			decoder, err = zlib.NewReader(reader)
+		}
+		if err != nil {
+			if isReadError(err) || errors.Is(err, ErrBodyTooLarge) {
+				return err
+			}
+			return fmt.Errorf("%w: %w", ErrInvalidEncodedBody, err)
+		}
+		d.closers = append(d.closers, decoder)
+		reader = decoder
+	}
+	d.decoder = reader
+	return nil
+}
+
+// Close discards the rest of the raw body. It returns ErrBodyTooLarge if
+// the decoded body went over the limit, like the body it wraps would.
+func (d *decodedBody) Close() error {
+	for _, closer := range d.closers {
+		_ = closer.Close()
+	}
+	if err := d.raw.Close(); err != nil {
+		return err
+	}
+	if errors.Is(d.err, ErrBodyTooLarge) {
+		return d.err
+	}
+	return nil
+}
+
+// isReadError tells errors of the underlying body, such as a connection
+// closed early, from corrupt compressed data.
+func isReadError(err error) bool {
+	return errors.Is(err, ErrUnexpectedEOF) || errors.Is(err, ErrMalformedChunk)
+}
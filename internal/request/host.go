@@ -0,0 +1,111 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidHost = errors.New("invalid Host header")
+
+// Host is the authority a request is addressed to. Name is lower-cased and
+// keeps the brackets of an IPv6 literal; Port is empty when none was given.
+type Host struct {
+	Name string
+	Port string
+}
+
+func (h Host) String() string {
+	if h.Port == "" {
+		return h.Name
+	}
+	return h.Name + ":" + h.Port
+}
+
+// parseHost checks the Host header once the headers are complete. HTTP/1.1
+// requires exactly one; for an absolute-form target RFC 9112 has the target's
+// authority take precedence over it.
+func (r *Request) parseHost() error {
+	values := r.Headers.Values("Host")
+	switch {
+	case len(values) > 1:
+		return fmt.Errorf("%w: %d Host headers", ErrInvalidHost, len(values))
+	case len(values) == 0 && r.RequestLine.AtLeast(1, 1):
+		return fmt.Errorf("%w: missing in an HTTP/1.1 request", ErrInvalidHost)
+	}
+
+	authority := ""
+	if len(values) == 1 {
+		authority = values[0]
+	}
+	if _, err := ParseHost(authority); err != nil {
+		return err
+	}
+	if r.RequestLine.TargetForm == TargetAbsoluteForm || r.RequestLine.TargetForm == TargetAuthorityForm {
+		authority = r.RequestLine.Authority
+	}
+	host, err := ParseHost(authority)
+	if err != nil {
+		return err
+	}
+	r.Host = host
+	return nil
+}
+
+// ParseHost parses uri-host [ ":" port ] from RFC 3986. An empty authority is
+// valid and gives an empty Host.
+func ParseHost(authority string) (Host, error) {
+	name, port := authority, ""
+	if strings.HasPrefix(authority, "[") {
+		end := strings.IndexByte(authority, ']')
+		if end < 0 {
+			return Host{}, fmt.Errorf("%w: unterminated IP literal in %q", ErrInvalidHost, authority)
+		}
+		name = authority[:end+1]
+		rest := authority[end+1:]
+		if rest != "" {
+			var found bool
+			if port, found = strings.CutPrefix(rest, ":"); !found {
+				return Host{}, fmt.Errorf("%w: %q", ErrInvalidHost, authority)
+			}
+		}
+		if !validIPLiteral(name[1:end]) {
+			return Host{}, fmt.Errorf("%w: bad IP literal %q", ErrInvalidHost, name)
+		}
+	} else if i := strings.LastIndexByte(authority, ':'); i >= 0 {
+		name, port = authority[:i], authority[i+1:]
+	}
+
+	for i := 0; i < len(port); i++ {
+		if !isDigit(port[i]) {
+			return Host{}, fmt.Errorf("%w: bad port in %q", ErrInvalidHost, authority)
+		}
+	}
+	if !strings.HasPrefix(name, "[") {
+		for i := 0; i < len(name); i++ {
+			if !isRegNameByte(name[i]) {
+				return Host{}, fmt.Errorf("%w: byte %q not allowed in %q", ErrInvalidHost, name[i], authority)
+			}
+		}
+	}
+	return Host{Name: strings.ToLower(name), Port: port}, nil
+}
+
+// isRegNameByte allows unreserved characters, sub-delims and percent-escapes,
+// which covers host names and IPv4 addresses.
+func isRegNameByte(b byte) bool {
+	return isAlpha(b) || isDigit(b) || strings.IndexByte("-._~!$&'()*+,;=%", b) >= 0
+}
+
+func validIPLiteral(literal string) bool {
+	if literal == "" {
+		return false
+	}
+	for i := 0; i < len(literal); i++ {
+		b := literal[i]
+		if !isDigit(b) && !strings.ContainsRune("abcdefABCDEF:.", rune(b)) {
+			return false
+		}
+	}
+	return strings.Contains(literal, ":")
+}
@@ -14,6 +14,26 @@ import (
 const BUFFER_SIZE int = 8
 const CRLF = "\r\n"
 
+// MAX_CHUNK_LINE_LENGTH bounds a chunk-size line, extensions included, which
+// nothing else limits.
+const MAX_CHUNK_LINE_LENGTH = 4_096
+
+// Errors returned while parsing a request. Each one is wrapped with details
+// about the offending input, so match them with errors.Is.
+var (
+	ErrMalformedRequestLine        = errors.New("malformed request line")
+	ErrUnsupportedVersion          = errors.New("unsupported HTTP version")
+	ErrMalformedHeader             = errors.New("malformed header")
+	ErrHeaderTooLarge              = errors.New("request headers too large")
+	ErrInvalidContentLength        = errors.New("invalid Content-Length")
+	ErrAmbiguousFraming            = errors.New("request cannot contain both Content-Length and Transfer-Encoding")
+	ErrUnsupportedTransferEncoding = errors.New("unsupported Transfer-Encoding")
+	ErrMalformedChunk              = errors.New("malformed chunked body")
+	ErrBodyTooLarge                = errors.New("request body too large")
+	// ErrUnexpectedEOF also matches io.ErrUnexpectedEOF.
+	ErrUnexpectedEOF = fmt.Errorf("incomplete HTTP request: %w", io.ErrUnexpectedEOF)
+)
+
 type parserState int
 
 const (
@@ -21,26 +41,78 @@ const (
 	parserStateInitialized
 	parserStateParsingHeaders
 	parserStateParsingBody
+	parserStateParsingChunkSize
+	parserStateParsingChunkData
+	parserStateParsingChunkDataEnd
+	parserStateParsingTrailers
 	parserStateDone
 )
 
 type Request struct {
-	RequestLine   RequestLine
-	Headers       headers.Headers
-	state         parserState
-	Body          []byte
-	bodyBytesRead int
+	RequestLine RequestLine
+	Headers     *headers.Headers
+	Trailers    *headers.Headers
+	// Params holds the path parameters captured by the router.
+	Params map[string]string
+	// Host is where the request is addressed, from the Host header or an
+	// absolute-form target.
+	Host Host
+	// RemoteAddr is the address of the client, set by the server.
+	RemoteAddr string
+	state      parserState
+	// Body holds the whole body once it has been buffered, either by
+	// RequestFromReader or by ReadBody.
+	Body []byte
+	// BodyReader streams the body bounded to its framing. Closing it
+	// discards whatever the handler did not read.
+	BodyReader io.ReadCloser
+	// ContentLength is the length of the body, 0 when there is none and -1
+	// when it is unknown, as for chunked or decoded bodies. Unlike the
+	// headers, it stays accurate once DecodeBody rewrote them.
+	ContentLength int64
+	// bodyBytesRemaining counts down a Content-Length body as it is parsed,
+	// which DecodeBody changing ContentLength must not affect.
+	bodyBytesRemaining  int64
+	bodyBytesRead       int
+	chunkBytesRemaining int
+	headerBytes         int
+	trailerBytes        int
+	maxBodyBytes        int64
+
+	streaming  bool
+	readBuffer []byte
+	readN      int
 }
 
 type RequestLine struct {
 	HttpVersion   string
 	RequestTarget string
 	Method        string
+	// VersionMajor and VersionMinor are the parsed HttpVersion numbers.
+	VersionMajor int
+	VersionMinor int
+
+	// TargetForm says how RequestTarget was written. Scheme and Authority
+	// are only set for the absolute and authority forms.
+	TargetForm TargetForm
+	Scheme     string
+	Authority  string
+	// Path is the decoded path of an origin- or absolute-form target, and
+	// RawPath the same path as sent.
+	Path     string
+	RawPath  string
+	RawQuery string
+	Query    Query
+}
+
+// AtLeast reports whether the request uses at least HTTP/major.minor.
+func (rl RequestLine) AtLeast(major, minor int) bool {
+	return rl.VersionMajor > major || (rl.VersionMajor == major && rl.VersionMinor >= minor)
 }
 
 func (r Request) PrettyPrint() string {
 	headersString := ""
-	for key, value := range r.Headers {
+	for key, value := range r.Headers.All() {
 		headersString += fmt.Sprintf("- %s: %s\n", key, value)
 	}
 	bodyString := ""
@@ -50,9 +122,9 @@ func (r Request) PrettyPrint() string {
 	return fmt.Sprintf(`Request line:
 - Method: %s
 - Target: %s
-- Version: 1.1
+- Version: %s
 Headers:
-%s%s`, r.RequestLine.Method, r.RequestLine.RequestTarget, headersString, bodyString)
+%s%s`, r.RequestLine.Method, r.RequestLine.RequestTarget, r.RequestLine.HttpVersion, headersString, bodyString)
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -71,42 +143,75 @@ func (r *Request) parse(data []byte) (int, error) {
 	case parserStateParsingHeaders:
 		n, done, err := r.Headers.Parse(data)
 		if err != nil {
-			return 0, err
+			return 0, fmt.Errorf("%w: %w", ErrMalformedHeader, err)
 		}
 		if done {
-			r.state = parserStateParsingBody
+			if err := r.parseHost(); err != nil {
+				return 0, err
+			}
+			if err := r.startBody(); err != nil {
+				return 0, err
+			}
 		}
 		return n, nil
 	case parserStateParsingBody:
-		if r.Body == nil {
-			contentLengthString, exists := r.Headers.Get("Content-Length")
-			if !exists {
-				r.state = parserStateDone
-				return 0, nil
-			}
+		// anything past the declared length belongs to the next request
+		n := r.emit(data[:min(int64(len(data)), r.bodyBytesRemaining)])
+		r.bodyBytesRemaining -= int64(n)
+		if r.bodyBytesRemaining == 0 {
+			r.state = parserStateDone
+		}
 
-			contentLength, err := strconv.Atoi(contentLengthString)
-			if err != nil {
-				return 0, fmt.Errorf("invalid Content-Length header value %q: %w", contentLengthString, err)
-			}
+		return n, nil
 
-			if contentLength < 0 {
-				return 0, fmt.Errorf("invalid negative Content-Length: %d", contentLength)
-			}
-			r.Body = make([]byte, contentLength)
-			r.bodyBytesRead = 0
+	case parserStateParsingChunkSize:
+		index := bytes.Index(data, []byte(CRLF))
+		if index > MAX_CHUNK_LINE_LENGTH || (index == -1 && len(data) > MAX_CHUNK_LINE_LENGTH) {
+			return 0, fmt.Errorf("%w: chunk size line longer than %d bytes", ErrMalformedChunk, MAX_CHUNK_LINE_LENGTH)
 		}
-
-		if r.bodyBytesRead+len(data) > len(r.Body) {
-			return 0, fmt.Errorf("request body too large: %d bytes read, %d bytes remaining", r.bodyBytesRead, len(r.Body)-r.bodyBytesRead)
+		if index == -1 {
+			return 0, nil
 		}
 
-		n := copy(r.Body[r.bodyBytesRead:], data)
+		size, err := parseChunkSize(string(data[:index]))
+		if err != nil {
+			return 0, err
+		}
+		if r.maxBodyBytes > 0 && int64(r.bodyBytesRead)+int64(size) > r.maxBodyBytes {
+			return 0, ErrBodyTooLarge
+		}
+		if size == 0 {
+			r.state = parserStateParsingTrailers
+		} else {
+			r.chunkBytesRemaining = size
+			r.state = parserStateParsingChunkData
+		}
+		return index + len(CRLF), nil
+	case parserStateParsingChunkData:
+		n := r.emit(data[:min(len(data), r.chunkBytesRemaining)])
+		r.chunkBytesRemaining -= n
 		r.bodyBytesRead += n
-		if r.bodyBytesRead == len(r.Body) {
+		if r.chunkBytesRemaining == 0 {
+			r.state = parserStateParsingChunkDataEnd
+		}
+		return n, nil
+	case parserStateParsingChunkDataEnd:
+		if len(data) < len(CRLF) {
+			return 0, nil
+		}
+		if string(data[:len(CRLF)]) != CRLF {
+			return 0, fmt.Errorf("%w: chunk data is not terminated by CRLF", ErrMalformedChunk)
+		}
+		r.state = parserStateParsingChunkSize
+		return len(CRLF), nil
+	case parserStateParsingTrailers:
+		n, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, fmt.Errorf("%w: trailer: %w", ErrMalformedHeader, err)
+		}
+		if done {
 			r.state = parserStateDone
 		}
-
 		return n, nil
 
 	case parserStateDone:
@@ -116,6 +221,115 @@ func (r *Request) parse(data []byte) (int, error) {
 	}
 }
 
+// startBody picks the body framing once the headers are complete.
+func (r *Request) startBody() error {
+	transferEncoding, chunked := r.Headers.Get("Transfer-Encoding")
+	contentLengthString, exists := r.Headers.Get("Content-Length")
+	if chunked && exists {
+		return ErrAmbiguousFraming
+	}
+	if chunked {
+		if !r.RequestLine.AtLeast(1, 1) {
+			// RFC 9112 treats Transfer-Encoding in HTTP/1.0 as faulty framing
+			return fmt.Errorf("%w: Transfer-Encoding in an HTTP/1.0 request", ErrMalformedHeader)
+		}
+		if !isChunked(transferEncoding) {
+			return fmt.Errorf("%w: %q", ErrUnsupportedTransferEncoding, transferEncoding)
+		}
+		r.initBody()
+		r.ContentLength = -1
+		r.state = parserStateParsingChunkSize
+		return nil
+	}
+	if !exists {
+		r.state = parserStateDone
+		return nil
+	}
+
+	contentLength, err := parseContentLength(contentLengthString)
+	if err != nil {
+		return err
+	}
+	if r.maxBodyBytes > 0 && contentLength > r.maxBodyBytes {
+		return ErrBodyTooLarge
+	}
+	r.initBody()
+	r.ContentLength = contentLength
+	r.bodyBytesRemaining = contentLength
+	if contentLength == 0 {
+		r.state = parserStateDone
+	} else {
+		r.state = parserStateParsingBody
+	}
+	return nil
+}
+
+func (r *Request) initBody() {
+	if !r.streaming {
+		r.Body = []byte{}
+	}
+}
+
+// emit hands body bytes either to the buffered Body or to the pending
+// BodyReader.Read call, returning how many of them were taken.
+func (r *Request) emit(data []byte) int {
+	if !r.streaming {
+		r.Body = append(r.Body, data...)
+		return len(data)
+	}
+	n := copy(r.readBuffer[r.readN:], data)
+	r.readN += n
+	return n
+}
+
+// ReadBody buffers the rest of a streamed body into Body and returns it.
+func (r *Request) ReadBody() ([]byte, error) {
+	if !r.streaming {
+		return r.Body, nil
+	}
+	if r.Body == nil {
+		r.Body = []byte{}
+	}
+	body, err := io.ReadAll(r.BodyReader)
+	r.Body = append(r.Body, body...)
+	return r.Body, err
+}
+
+// isChunked reports whether chunked is the only transfer coding. Other
+// codings would hand handlers a body still encoded, and are refused rather
+// than read differently than an intermediary might.
+func isChunked(transferEncoding string) bool {
+	return strings.EqualFold(strings.TrimSpace(transferEncoding), "chunked")
+}
+
+// parseContentLength accepts only 1*DIGIT, RFC 9112 section 6.3, so that no
+// sign, space or list is read differently than an intermediary might.
+func parseContentLength(value string) (int64, error) {
+	if value == "" || strings.ContainsFunc(value, func(r rune) bool { return r < '0' || r > '9' }) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidContentLength, value)
+	}
+	contentLength, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidContentLength, value)
+	}
+	return contentLength, nil
+}
+
+// parseChunkSize parses a chunk-size line, ignoring any chunk extensions.
+func parseChunkSize(line string) (int, error) {
+	sizeString, _, _ := strings.Cut(line, ";")
+	sizeString = strings.TrimRight(sizeString, " \t")
+	if sizeString == "" {
+		return 0, fmt.Errorf("%w: missing chunk size", ErrMalformedChunk)
+	}
+
+	size, err := strconv.ParseUint(sizeString, 16, 31)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid chunk size %q", ErrMalformedChunk, sizeString)
+	}
+	return int(size), nil
+}
+
 func parseRequestLine(data []byte) (*RequestLine, int, error) {
 	index := bytes.Index(data, []byte(CRLF))
 	if index == -1 {
@@ -133,67 +347,272 @@ func parseRequestLine(data []byte) (*RequestLine, int, error) {
 func buildRequestLine(header string) (*RequestLine, error) {
 	fields := strings.Fields(header)
 	if len(fields) != 3 {
-		return nil, fmt.Errorf("request line does not have three components")
+		return nil, fmt.Errorf("%w: request line does not have three components", ErrMalformedRequestLine)
 	}
 
 	method, requestTarget, httpVersion := fields[0], fields[1], fields[2]
 
 	for _, r := range method {
 		if !unicode.IsUpper(r) {
-			return nil, fmt.Errorf("request method can only be uppercase runes")
+			return nil, fmt.Errorf("%w: request method can only be uppercase runes", ErrMalformedRequestLine)
 		}
 	}
 
-	if httpVersion != "HTTP/1.1" {
-		return nil, fmt.Errorf("we only support HTTP/1.1")
+	if !validVersion(httpVersion) {
+		return nil, fmt.Errorf("%w: invalid HTTP version %q", ErrMalformedRequestLine, httpVersion)
 	}
 	httpVersion = strings.TrimPrefix(httpVersion, "HTTP/")
+	major, minor := int(httpVersion[0]-'0'), int(httpVersion[2]-'0')
+	if major != 1 {
+		return nil, fmt.Errorf("%w: HTTP/%s", ErrUnsupportedVersion, httpVersion)
+	}
+
+	requestLine := &RequestLine{
+		HttpVersion:   httpVersion,
+		RequestTarget: requestTarget,
+		Method:        method,
+		VersionMajor:  major,
+		VersionMinor:  minor,
+	}
+	if err := requestLine.parseTarget(); err != nil {
+		return nil, err
+	}
+	return requestLine, nil
+}
+
+// validVersion checks the HTTP-version syntax, "HTTP/" DIGIT "." DIGIT.
+func validVersion(httpVersion string) bool {
+	digits, found := strings.CutPrefix(httpVersion, "HTTP/")
+	return found && len(digits) == 3 && isDigit(digits[0]) && digits[1] == '.' && isDigit(digits[2])
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// Reader parses consecutive requests from a single connection, keeping any
+// bytes read past the end of one request for the next one.
+type Reader struct {
+	reader      io.Reader
+	buffer      []byte
+	readToIndex int
+	current     *Request
 
-	return &RequestLine{HttpVersion: httpVersion, RequestTarget: requestTarget, Method: method}, nil
+	// MaxHeaderBytes limits the request line and headers; 0 means no limit.
+	MaxHeaderBytes int
+	// MaxBodyBytes limits the decoded body; 0 means no limit.
+	MaxBodyBytes int64
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{reader: reader, buffer: make([]byte, BUFFER_SIZE)}
 }
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	buffer := make([]byte, BUFFER_SIZE)
-	readToIndex := 0
-	r := &Request{state: parserStateInitialized, Headers: headers.NewHeaders()}
+	return NewReader(reader).ReadRequest()
+}
 
-	for r.state != parserStateDone {
-		if readToIndex >= len(buffer) {
-			newBuffer := make([]byte, 2*readToIndex)
-			copy(newBuffer, buffer)
-			buffer = newBuffer
-		}
-
-		n, err := reader.Read(buffer[readToIndex:])
-		if n > 0 {
-			readToIndex += n
-			for {
-				consumed, parseErr := r.parse(buffer[:readToIndex])
-				if parseErr != nil {
-					return nil, parseErr
-				}
-				if consumed == 0 || r.state == parserStateDone {
-					break
-				}
+// ReadRequest parses the next request, buffering its body into Body. It
+// returns io.EOF when the connection is closed before any byte of a new
+// request arrives.
+func (rr *Reader) ReadRequest() (*Request, error) {
+	return rr.readRequest(false)
+}
 
-				remaining := readToIndex - consumed
-				if remaining > 0 {
-					copy(buffer, buffer[consumed:readToIndex])
-				}
-				readToIndex = remaining
-			}
+// StreamRequest parses the next request line and headers and returns without
+// reading the body, which is left to Request.BodyReader.
+func (rr *Reader) StreamRequest() (*Request, error) {
+	return rr.readRequest(true)
+}
+
+func (rr *Reader) readRequest(streaming bool) (*Request, error) {
+	if rr.current != nil {
+		// the previous body has to be consumed before the next request starts
+		if err := rr.current.BodyReader.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	rr.current = nil
+
+	r := &Request{
+		state:        parserStateInitialized,
+		Headers:      headers.NewHeaders(),
+		Trailers:     headers.NewHeaders(),
+		streaming:    streaming,
+		maxBodyBytes: rr.MaxBodyBytes,
+	}
+	ready := func() bool {
+		if streaming {
+			return r.state > parserStateParsingHeaders
+		}
+		return r.state == parserStateDone
+	}
+
+	for {
+		if err := rr.parseBuffered(r); err != nil {
+			return nil, err
+		}
+		if rr.headersTooLarge(r) {
+			return nil, ErrHeaderTooLarge
 		}
+		if ready() {
+			break
+		}
+
+		err := rr.fill()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
-				if r.state != parserStateDone {
-					return nil, fmt.Errorf("incomplete HTTP request: connection closed unexpectedly (EOF) in state %v with %d bytes remaining in buffer: [%v]", r.state, readToIndex, buffer[:readToIndex])
+				if r.state == parserStateInitialized && rr.readToIndex == 0 {
+					return nil, io.EOF
 				}
-				break
+				return nil, ErrUnexpectedEOF
 			}
 			return nil, err
 		}
-
 	}
 
+	if streaming {
+		r.BodyReader = &bodyReader{reader: rr, request: r}
+	} else {
+		r.BodyReader = io.NopCloser(bytes.NewReader(r.Body))
+	}
+	rr.current = r
 	return r, nil
 }
+
+// headersTooLarge checks the parsed header bytes plus, while the headers are
+// still incomplete, the partial line waiting in the buffer. The trailer
+// section is held to the same limit.
+func (rr *Reader) headersTooLarge(r *Request) bool {
+	if rr.MaxHeaderBytes <= 0 {
+		return false
+	}
+	switch {
+	case r.state <= parserStateParsingHeaders:
+		return r.headerBytes+rr.readToIndex > rr.MaxHeaderBytes
+	case r.state == parserStateParsingTrailers:
+		return r.trailerBytes+rr.readToIndex > rr.MaxHeaderBytes
+	default:
+		return r.headerBytes > rr.MaxHeaderBytes || r.trailerBytes > rr.MaxHeaderBytes
+	}
+}
+
+// WaitForData blocks until at least one byte of the next request is
+// available, so callers can tell an idle connection from a slow request.
+func (rr *Reader) WaitForData() error {
+	for rr.readToIndex == 0 {
+		if err := rr.fill(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fill reads more bytes from the connection into the buffer, growing it when
+// it is full. Bytes that arrive together with an error are kept.
+func (rr *Reader) fill() error {
+	if rr.readToIndex >= len(rr.buffer) {
+		newBuffer := make([]byte, 2*rr.readToIndex)
+		copy(newBuffer, rr.buffer)
+		rr.buffer = newBuffer
+	}
+
+	n, err := rr.reader.Read(rr.buffer[rr.readToIndex:])
+	rr.readToIndex += n
+	if n > 0 {
+		return nil
+	}
+	return err
+}
+
+func (rr *Reader) parseBuffered(r *Request) error {
+	for r.state != parserStateDone {
+		state := r.state
+		consumed, err := r.parse(rr.buffer[:rr.readToIndex])
+		if err != nil {
+			return err
+		}
+		if consumed == 0 && r.state == state {
+			return nil
+		}
+		if state <= parserStateParsingHeaders {
+			r.headerBytes += consumed
+		} else if state == parserStateParsingTrailers {
+			r.trailerBytes += consumed
+		}
+
+		remaining := rr.readToIndex - consumed
+		if remaining > 0 {
+			copy(rr.buffer, rr.buffer[consumed:rr.readToIndex])
+		}
+		rr.readToIndex = remaining
+	}
+	return nil
+}
+
+type bodyReader struct {
+	reader  *Reader
+	request *Request
+	err     error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	r := b.request
+	if r.state == parserStateDone {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	r.readBuffer, r.readN = p, 0
+	defer func() { r.readBuffer = nil }()
+
+	for {
+		if err := b.reader.parseBuffered(r); err != nil {
+			b.err = err
+			return r.readN, err
+		}
+		if b.reader.headersTooLarge(r) {
+			b.err = fmt.Errorf("%w: trailer section", ErrHeaderTooLarge)
+			return r.readN, b.err
+		}
+		if r.readN > 0 {
+			return r.readN, nil
+		}
+		if r.state == parserStateDone {
+			return 0, io.EOF
+		}
+
+		if err := b.reader.fill(); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = ErrUnexpectedEOF
+			}
+			b.err = err
+			return 0, err
+		}
+	}
+}
+
+// Close discards the unread rest of the body so the connection is positioned
+// at the start of the next request.
+func (b *bodyReader) Close() error {
+	if b.err != nil {
+		if errors.Is(b.err, errBodyClosed) {
+			return nil
+		}
+		return b.err
+	}
+	_, err := io.Copy(io.Discard, b)
+	if err != nil {
+		return err
+	}
+	b.err = errBodyClosed
+	return nil
+}
+
+var errBodyClosed = errors.New("request body is closed")
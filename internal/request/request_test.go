@@ -1,7 +1,12 @@
 package request
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"httpfromtcp/internal/headers"
 	"io"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -34,6 +39,11 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+func get(h *headers.Headers, key string) string {
+	value, _ := h.Get(key)
+	return value
+}
+
 func TestGoodGetRequestLine(t *testing.T) {
 	reader := &chunkReader{
 		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
@@ -75,9 +85,9 @@ func TestStandardHeaders(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", get(r.Headers, "host"))
+	assert.Equal(t, "curl/7.81.0", get(r.Headers, "user-agent"))
+	assert.Equal(t, "*/*", get(r.Headers, "accept"))
 }
 
 func TestMalformedHeader(t *testing.T) {
@@ -89,6 +99,13 @@ func TestMalformedHeader(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestWhitespaceBeforeFirstHeader(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n Host: evil\r\n\r\n"))
+	require.ErrorIs(t, err, ErrMalformedHeader)
+	assert.ErrorIs(t, err, headers.ErrInvalidFieldName)
+	assert.Nil(t, r)
+}
+
 func TestEmptyHeader(t *testing.T) {
 	reader := &chunkReader{
 		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: \r\n\r\n",
@@ -97,29 +114,30 @@ func TestEmptyHeader(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", r.Headers["user-agent"])
+	assert.Equal(t, "", get(r.Headers, "user-agent"))
 }
 
 func TestDuplicateHeader(t *testing.T) {
 	reader := &chunkReader{
-		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nHost: localhost:42070\r\n\r\n",
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept: text/html\r\nAccept: text/plain\r\n\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069, localhost:42070", r.Headers["host"])
+	assert.Equal(t, "text/html, text/plain", get(r.Headers, "accept"))
 }
 
 func TestCaseInsensitiveHeader(t *testing.T) {
 	reader := &chunkReader{
-		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nhOsT: localhost:42070\r\n\r\n",
+		data:            "GET / HTTP/1.1\r\nhOsT: localhost:42069\r\nAccept: text/html\r\naCcEpT: text/plain\r\n\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069, localhost:42070", r.Headers["host"])
+	assert.Equal(t, "localhost:42069", get(r.Headers, "host"))
+	assert.Equal(t, "text/html, text/plain", get(r.Headers, "accept"))
 }
 
 func TestMissingEndOfHeaders(t *testing.T) {
@@ -158,3 +176,485 @@ func TestBodyShorterThanReportedContentLength(t *testing.T) {
 	_, err := RequestFromReader(reader)
 	require.Error(t, err)
 }
+
+func TestPipelinedRequests(t *testing.T) {
+	reader := NewReader(&chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /coffee HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 64,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/submit", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(r.Body))
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/coffee", r.RequestLine.RequestTarget)
+
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestChunkedBody(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6\r\nhello \r\n" +
+			"7;name=value\r\nworld!\n\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+}
+
+func TestChunkedBodyWithTrailers(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"A\r\n0123456789\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "0123456789", string(r.Body))
+	assert.Equal(t, "abc123", get(r.Trailers, "x-checksum"))
+}
+
+func TestInvalidChunkSize(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\nhello\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err := RequestFromReader(reader)
+	require.Error(t, err)
+}
+
+func TestContentLengthAndTransferEncoding(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err := RequestFromReader(reader)
+	require.Error(t, err)
+}
+
+func TestStreamedBody(t *testing.T) {
+	reader := NewReader(&chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n" +
+			"GET / HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	})
+	r, err := reader.StreamRequest()
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Nil(t, r.Body)
+
+	p := make([]byte, 5)
+	n, err := r.BodyReader.Read(p)
+	require.NoError(t, err)
+	assert.Positive(t, n)
+
+	rest, err := io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(p[:n])+string(rest))
+
+	r, err = reader.StreamRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/", r.RequestLine.RequestTarget)
+}
+
+func TestStreamedChunkedBody(t *testing.T) {
+	reader := NewReader(&chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6\r\nhello \r\n" +
+			"7\r\nworld!\n\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	})
+	r, err := reader.StreamRequest()
+	require.NoError(t, err)
+	require.NotNil(t, r)
+
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+}
+
+func TestUnreadStreamedBodyIsDiscarded(t *testing.T) {
+	reader := NewReader(&chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n" +
+			"GET /coffee HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	})
+	_, err := reader.StreamRequest()
+	require.NoError(t, err)
+
+	r, err := reader.StreamRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/coffee", r.RequestLine.RequestTarget)
+}
+
+func TestStreamedBodyShorterThanReportedContentLength(t *testing.T) {
+	reader := NewReader(&chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 20\r\n" +
+			"\r\n" +
+			"partial content",
+		numBytesPerRead: 3,
+	})
+	r, err := reader.StreamRequest()
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(r.BodyReader)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestHeadersTooLarge(t *testing.T) {
+	reader := NewReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Padding: " + strings.Repeat("a", 100) + "\r\n\r\n",
+		numBytesPerRead: 16,
+	})
+	reader.MaxHeaderBytes = 64
+	_, err := reader.ReadRequest()
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	reader := NewReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: 13\r\n" +
+		"\r\n" +
+		"hello world!\n"))
+	reader.MaxBodyBytes = 8
+	_, err := reader.ReadRequest()
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+func TestChunkedBodyTooLarge(t *testing.T) {
+	reader := NewReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"6\r\nhello \r\n" +
+		"7\r\nworld!\n\r\n" +
+		"0\r\n\r\n"))
+	reader.MaxBodyBytes = 8
+	r, err := reader.StreamRequest()
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(r.BodyReader)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"malformed request line", "GET /\r\nHost: localhost\r\n\r\n", ErrMalformedRequestLine},
+		{"lowercase method", "get / HTTP/1.1\r\nHost: localhost\r\n\r\n", ErrMalformedRequestLine},
+		{"malformed version", "GET / HTTX/1.1\r\nHost: localhost\r\n\r\n", ErrMalformedRequestLine},
+		{"unsupported version", "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n", ErrUnsupportedVersion},
+		{"malformed header", "GET / HTTP/1.1\r\nHost localhost\r\n\r\n", ErrMalformedHeader},
+		{"invalid content length", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: abc\r\n\r\n", ErrInvalidContentLength},
+		{"negative content length", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: -1\r\n\r\n", ErrInvalidContentLength},
+		{"signed content length", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: +3\r\n\r\nabc", ErrInvalidContentLength},
+		{"content length list", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3, 3\r\n\r\nabc", ErrInvalidContentLength},
+		{"overflowing content length", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 99999999999999999999\r\n\r\n", ErrInvalidContentLength},
+		{"ambiguous framing", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1\r\nTransfer-Encoding: chunked\r\n\r\n", ErrAmbiguousFraming},
+		{"unsupported transfer encoding", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip\r\n\r\n", ErrUnsupportedTransferEncoding},
+		{"coding before chunked", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip, chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n", ErrUnsupportedTransferEncoding},
+		{"chunked twice", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", ErrUnsupportedTransferEncoding},
+		{"malformed chunk", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nabc\r\n0\r\n\r\n", ErrMalformedChunk},
+		{"unexpected EOF", "GET / HTTP/1.1\r\nHost: localhost\r\n", ErrUnexpectedEOF},
+		{"bad escape", "GET /a%zz HTTP/1.1\r\nHost: localhost\r\n\r\n", ErrInvalidTarget},
+		{"truncated escape", "GET /a?b=%4 HTTP/1.1\r\nHost: localhost\r\n\r\n", ErrInvalidTarget},
+		{"escaped NUL", "GET /a%00 HTTP/1.1\r\nHost: localhost\r\n\r\n", ErrInvalidTarget},
+		{"invalid target byte", "GET /a\"b HTTP/1.1\r\nHost: localhost\r\n\r\n", ErrInvalidTarget},
+		{"relative target", "GET a/b HTTP/1.1\r\nHost: localhost\r\n\r\n", ErrInvalidTarget},
+		{"asterisk without OPTIONS", "GET * HTTP/1.1\r\nHost: localhost\r\n\r\n", ErrInvalidTarget},
+		{"missing host", "GET / HTTP/1.1\r\n\r\n", ErrInvalidHost},
+		{"duplicate host", "GET / HTTP/1.1\r\nHost: a.test\r\nHost: b.test\r\n\r\n", ErrInvalidHost},
+		{"host with path", "GET / HTTP/1.1\r\nHost: a.test/x\r\n\r\n", ErrInvalidHost},
+		{"host with bad port", "GET / HTTP/1.1\r\nHost: a.test:http\r\n\r\n", ErrInvalidHost},
+		{"host with bad IP literal", "GET / HTTP/1.1\r\nHost: [zz]:80\r\n\r\n", ErrInvalidHost},
+		{"CONNECT without port", "CONNECT example.com HTTP/1.1\r\nHost: example.com\r\n\r\n", ErrInvalidTarget},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RequestFromReader(strings.NewReader(tt.data))
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestHTTP10RequestLine(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	assert.Equal(t, 1, r.RequestLine.VersionMajor)
+	assert.Equal(t, 0, r.RequestLine.VersionMinor)
+	assert.False(t, r.RequestLine.AtLeast(1, 1))
+
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, r.RequestLine.VersionMinor)
+	assert.True(t, r.RequestLine.AtLeast(1, 1))
+}
+
+func TestUnknownMajorVersion(t *testing.T) {
+	_, err := RequestFromReader(strings.NewReader("GET / HTTP/3.0\r\nHost: localhost\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func TestHTTP10TransferEncoding(t *testing.T) {
+	_, err := RequestFromReader(strings.NewReader("POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrMalformedHeader)
+}
+
+func TestRequestTargetForms(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET /caf%C3%A9/a%2Fb?q=go+lang&tag=a&tag=b%26c&flag HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, TargetOriginForm, r.RequestLine.TargetForm)
+	assert.Equal(t, "/café/a/b", r.RequestLine.Path)
+	assert.Equal(t, "/caf%C3%A9/a%2Fb", r.RequestLine.RawPath)
+	assert.Equal(t, "q=go+lang&tag=a&tag=b%26c&flag", r.RequestLine.RawQuery)
+	assert.Equal(t, "go lang", r.RequestLine.Query.Get("q"))
+	assert.Equal(t, []string{"a", "b&c"}, r.RequestLine.Query["tag"])
+	assert.True(t, r.RequestLine.Query.Has("flag"))
+	assert.False(t, r.RequestLine.Query.Has("missing"))
+
+	r, err = RequestFromReader(strings.NewReader("GET http://example.com:8080/x?y=1 HTTP/1.1\r\nHost: example.com:8080\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, TargetAbsoluteForm, r.RequestLine.TargetForm)
+	assert.Equal(t, "http", r.RequestLine.Scheme)
+	assert.Equal(t, "example.com:8080", r.RequestLine.Authority)
+	assert.Equal(t, "/x", r.RequestLine.Path)
+	assert.Equal(t, "1", r.RequestLine.Query.Get("y"))
+
+	r, err = RequestFromReader(strings.NewReader("GET http://example.com HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "/", r.RequestLine.Path)
+
+	r, err = RequestFromReader(strings.NewReader("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, TargetAuthorityForm, r.RequestLine.TargetForm)
+	assert.Equal(t, "example.com:443", r.RequestLine.Authority)
+
+	r, err = RequestFromReader(strings.NewReader("OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, TargetAsteriskForm, r.RequestLine.TargetForm)
+}
+
+func TestHost(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: Example.COM:8080\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, Host{Name: "example.com", Port: "8080"}, r.Host)
+	assert.Equal(t, "example.com:8080", r.Host.String())
+
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: [::1]:443\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, Host{Name: "[::1]", Port: "443"}, r.Host)
+
+	r, err = RequestFromReader(strings.NewReader("GET http://origin.test/ HTTP/1.1\r\nHost: other.test\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, Host{Name: "origin.test"}, r.Host)
+
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, Host{}, r.Host)
+}
+
+func compress(t *testing.T, coding string, data []byte) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	var w io.WriteCloser
+	switch coding {
+	case "gzip":
+		w = gzip.NewWriter(&buffer)
+	case "deflate":
+		w = zlib.NewWriter(&buffer)
+	}
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buffer.Bytes()
+}
+
+func encodedRequest(contentEncoding string, body []byte) string {
+	return "POST /upload HTTP/1.1\r\nHost: localhost\r\n" +
+		"Content-Encoding: " + contentEncoding + "\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + string(body)
+}
+
+func TestDecodeBody(t *testing.T) {
+	payload := []byte(strings.Repeat("hello world\n", 100))
+	tests := []struct {
+		contentEncoding string
+		body            []byte
+	}{
+		{"gzip", compress(t, "gzip", payload)},
+		{"x-gzip", compress(t, "gzip", payload)},
+		{"deflate", compress(t, "deflate", payload)},
+		{"deflate, gzip", compress(t, "gzip", compress(t, "deflate", payload))},
+		{"identity", payload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentEncoding, func(t *testing.T) {
+			r, err := RequestFromReader(strings.NewReader(encodedRequest(tt.contentEncoding, tt.body)))
+			require.NoError(t, err)
+			require.NoError(t, r.DecodeBody(0))
+			assert.Equal(t, payload, r.Body)
+			assert.Equal(t, int64(len(payload)), r.ContentLength)
+			_, ok := r.Headers.Get("Content-Encoding")
+			assert.Equal(t, tt.contentEncoding == "identity", ok)
+
+			reader := NewReader(&chunkReader{data: encodedRequest(tt.contentEncoding, tt.body), numBytesPerRead: 7})
+			r, err = reader.StreamRequest()
+			require.NoError(t, err)
+			require.NoError(t, r.DecodeBody(0))
+			if tt.contentEncoding != "identity" {
+				assert.Equal(t, int64(-1), r.ContentLength)
+			}
+			body, err := io.ReadAll(r.BodyReader)
+			require.NoError(t, err)
+			assert.Equal(t, payload, body)
+			assert.NoError(t, r.BodyReader.Close())
+		})
+	}
+}
+
+func TestDecodeBodyErrors(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader(encodedRequest("br", []byte("abc"))))
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.DecodeBody(0), ErrUnsupportedContentEncoding)
+	assert.Equal(t, []byte("abc"), r.Body)
+
+	r, err = RequestFromReader(strings.NewReader(encodedRequest("gzip", []byte("not gzip"))))
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.DecodeBody(0), ErrInvalidEncodedBody)
+
+	bomb := compress(t, "gzip", make([]byte, 1<<20))
+	reader := NewReader(strings.NewReader(encodedRequest("gzip", bomb)))
+	r, err = reader.StreamRequest()
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeBody(1_024))
+	body, err := io.ReadAll(r.BodyReader)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+	assert.Len(t, body, 1_024)
+	assert.ErrorIs(t, r.BodyReader.Close(), ErrBodyTooLarge)
+}
+
+// endlessReader returns prefix followed by filler bytes forever.
+type endlessReader struct {
+	prefix string
+	filler byte
+}
+
+func (e *endlessReader) Read(p []byte) (int, error) {
+	n := copy(p, e.prefix)
+	e.prefix = e.prefix[n:]
+	for i := n; i < len(p); i++ {
+		p[i] = e.filler
+	}
+	return len(p), nil
+}
+
+func TestChunkSizeLineTooLong(t *testing.T) {
+	reader := NewReader(&endlessReader{
+		prefix: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n1;",
+		filler: 'a',
+	})
+	reader.MaxHeaderBytes = 1 << 20
+	r, err := reader.StreamRequest()
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(r.BodyReader)
+	assert.ErrorIs(t, err, ErrMalformedChunk)
+	assert.LessOrEqual(t, len(reader.buffer), 4*MAX_CHUNK_LINE_LENGTH)
+}
+
+func TestTrailersTooLarge(t *testing.T) {
+	body := "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5\r\nhello\r\n0\r\n"
+
+	reader := NewReader(&endlessReader{prefix: body + "X-Trailer: ", filler: 'a'})
+	reader.MaxHeaderBytes = 1_024
+	r, err := reader.StreamRequest()
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader)
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	reader = NewReader(strings.NewReader(body + strings.Repeat("X-Trailer: "+strings.Repeat("a", 100)+"\r\n", 20) + "\r\n"))
+	reader.MaxHeaderBytes = 1_024
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+}
+
+func TestContentLength(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int64
+	}{
+		{"no body", "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", 0},
+		{"content length", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc", 3},
+		{"chunked", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := RequestFromReader(strings.NewReader(tt.data))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, r.ContentLength)
+		})
+	}
+}
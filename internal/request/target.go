@@ -0,0 +1,198 @@
+package request
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidTarget also matches ErrMalformedRequestLine.
+var ErrInvalidTarget = fmt.Errorf("%w: invalid request target", ErrMalformedRequestLine)
+
+// TargetForm is the form of a request target, RFC 9112 section 3.2.
+type TargetForm int
+
+const (
+	// TargetOriginForm is an absolute path with an optional query, "/a?b".
+	TargetOriginForm TargetForm = iota
+	// TargetAbsoluteForm is a full URI, sent to proxies.
+	TargetAbsoluteForm
+	// TargetAuthorityForm is "host:port", only used by CONNECT.
+	TargetAuthorityForm
+	// TargetAsteriskForm is "*", only used by a server-wide OPTIONS.
+	TargetAsteriskForm
+)
+
+// Query maps query parameter names to their values, in the order they
+// appear in the target.
+type Query map[string][]string
+
+// Get returns the first value of key, or "" when it is absent.
+func (q Query) Get(key string) string {
+	if values := q[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Has reports whether key appears in the query, even without a value.
+func (q Query) Has(key string) bool {
+	_, ok := q[key]
+	return ok
+}
+
+// parseTarget splits the request target into its parts on rl, checking that
+// its form is allowed for the method and that every escape decodes.
+func (rl *RequestLine) parseTarget() error {
+	target := rl.RequestTarget
+	for i := 0; i < len(target); i++ {
+		if !isTargetByte(target[i]) {
+			return fmt.Errorf("%w: byte %q not allowed", ErrInvalidTarget, target[i])
+		}
+	}
+
+	switch {
+	case rl.Method == "CONNECT":
+		host, port, ok := splitHostPort(target)
+		if !ok || host == "" || port == "" {
+			return fmt.Errorf("%w: CONNECT needs host:port, got %q", ErrInvalidTarget, target)
+		}
+		rl.TargetForm = TargetAuthorityForm
+		rl.Authority = target
+		return nil
+	case target == "*":
+		if rl.Method != "OPTIONS" {
+			return fmt.Errorf("%w: %q is only allowed with OPTIONS", ErrInvalidTarget, target)
+		}
+		rl.TargetForm = TargetAsteriskForm
+		return nil
+	case strings.HasPrefix(target, "/"):
+		rl.TargetForm = TargetOriginForm
+	default:
+		scheme, rest, found := strings.Cut(target, "://")
+		if !found || !validScheme(scheme) {
+			return fmt.Errorf("%w: %q is not a path or an absolute URI", ErrInvalidTarget, target)
+		}
+		authority, pathAndQuery := rest, "/"
+		if i := strings.IndexAny(rest, "/?"); i >= 0 {
+			authority, pathAndQuery = rest[:i], rest[i:]
+		}
+		if authority == "" {
+			return fmt.Errorf("%w: %q has no authority", ErrInvalidTarget, target)
+		}
+		if strings.HasPrefix(pathAndQuery, "?") {
+			pathAndQuery = "/" + pathAndQuery
+		}
+		rl.TargetForm = TargetAbsoluteForm
+		rl.Scheme = strings.ToLower(scheme)
+		rl.Authority = authority
+		target = pathAndQuery
+	}
+
+	rawPath, rawQuery, _ := strings.Cut(target, "?")
+	path, err := unescape(rawPath, false)
+	if err != nil {
+		return err
+	}
+	query, err := parseQuery(rawQuery)
+	if err != nil {
+		return err
+	}
+	rl.Path = path
+	rl.RawPath = rawPath
+	rl.RawQuery = rawQuery
+	rl.Query = query
+	return nil
+}
+
+// parseQuery decodes a&b=c pairs, treating "+" as a space as HTML forms do.
+func parseQuery(rawQuery string) (Query, error) {
+	query := Query{}
+	for pair := range strings.SplitSeq(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+		key, err := unescape(rawKey, true)
+		if err != nil {
+			return nil, err
+		}
+		value, err := unescape(rawValue, true)
+		if err != nil {
+			return nil, err
+		}
+		query[key] = append(query[key], value)
+	}
+	return query, nil
+}
+
+// unescape decodes percent-escapes. A decoded NUL is refused, since no
+// handler expects one in a path or a parameter.
+func unescape(s string, plusIsSpace bool) (string, error) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, nil
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '%':
+			if i+2 >= len(s) {
+				return "", fmt.Errorf("%w: truncated escape in %q", ErrInvalidTarget, s)
+			}
+			decoded, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil || decoded == 0 {
+				return "", fmt.Errorf("%w: bad escape %q", ErrInvalidTarget, s[i:i+3])
+			}
+			b.WriteByte(byte(decoded))
+			i += 2
+		case s[i] == '+' && plusIsSpace:
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// isTargetByte allows the characters RFC 3986 permits in a URI, without the
+// fragment delimiter, which clients never send.
+func isTargetByte(b byte) bool {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', isDigit(b):
+		return true
+	}
+	return strings.IndexByte("-._~!$&'()*+,;=:@/?%[]", b) >= 0
+}
+
+func validScheme(scheme string) bool {
+	if scheme == "" || !isAlpha(scheme[0]) {
+		return false
+	}
+	for i := 1; i < len(scheme); i++ {
+		if !isAlpha(scheme[i]) && !isDigit(scheme[i]) && !strings.ContainsRune("+-.", rune(scheme[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlpha(b byte) bool {
+	return ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+// splitHostPort splits an authority at its last colon, outside any IPv6
+// brackets.
+func splitHostPort(authority string) (host, port string, ok bool) {
+	i := strings.LastIndexByte(authority, ':')
+	if i < 0 || strings.IndexByte(authority[i:], ']') >= 0 {
+		return "", "", false
+	}
+	host, port = authority[:i], authority[i+1:]
+	for j := 0; j < len(port); j++ {
+		if !isDigit(port[j]) {
+			return "", "", false
+		}
+	}
+	return host, port, true
+}
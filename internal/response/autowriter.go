@@ -0,0 +1,170 @@
+package response
+
+import (
+	"errors"
+	"httpfromtcp/internal/headers"
+	"strconv"
+)
+
+// DEFAULT_BUFFER_THRESHOLD is how much body AutoWriter buffers before it
+// gives up on sending a Content-Length.
+const DEFAULT_BUFFER_THRESHOLD = 4 * 1_024
+
+var errAutoWriterClosed = errors.New("write after AutoWriter was closed")
+
+// AutoWriter frames a response on its own. Handlers fill in Header, call
+// WriteHeader and write the body through Write. Bodies that stay under
+// Threshold are sent with a Content-Length when the writer is closed; longer
+// ones are streamed with chunked coding, which Writer turns into a
+// close-delimited body for HTTP/1.0 clients. Declaring Content-Length or
+// Transfer-Encoding in Header skips the buffering.
+type AutoWriter struct {
+	w      *Writer
+	header *headers.Headers
+	status StatusCode
+	buffer []byte
+
+	// Threshold is the largest body sent with a computed Content-Length.
+	Threshold int
+
+	headersSent bool
+	chunked     bool
+	closed      bool
+}
+
+func NewAutoWriter(w *Writer) *AutoWriter {
+	return &AutoWriter{w: w, header: headers.NewHeaders(), Threshold: DEFAULT_BUFFER_THRESHOLD}
+}
+
+// Header returns the headers to send. Changes after the headers went out
+// have no effect.
+func (aw *AutoWriter) Header() *headers.Headers {
+	return aw.header
+}
+
+// WriteHeader sets the status code. Only the first call counts, and writing
+// the body without calling it sends 200 OK.
+func (aw *AutoWriter) WriteHeader(status StatusCode) {
+	if aw.status == 0 {
+		aw.status = status
+	}
+}
+
+func (aw *AutoWriter) Write(p []byte) (int, error) {
+	if aw.closed {
+		return 0, errAutoWriterClosed
+	}
+	aw.WriteHeader(StatusCodeOK)
+	if aw.headersSent {
+		return aw.writeBody(p)
+	}
+	if aw.declaresFraming() {
+		if err := aw.sendHeaders(); err != nil {
+			return 0, err
+		}
+		return aw.writeBody(p)
+	}
+
+	aw.buffer = append(aw.buffer, p...)
+	if len(aw.buffer) > aw.Threshold {
+		if err := aw.startChunked(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends the headers and whatever body is buffered, switching to
+// chunked coding since the final length is not known yet.
+func (aw *AutoWriter) Flush() error {
+	if aw.closed {
+		return errAutoWriterClosed
+	}
+	aw.WriteHeader(StatusCodeOK)
+	if aw.headersSent {
+		return nil
+	}
+	if aw.declaresFraming() {
+		return aw.sendHeaders()
+	}
+	return aw.startChunked()
+}
+
+// Close completes the response: a buffered body is sent with its
+// Content-Length, a chunked one gets its last chunk.
+func (aw *AutoWriter) Close() error {
+	if aw.closed {
+		return nil
+	}
+	aw.WriteHeader(StatusCodeOK)
+	defer func() { aw.closed = true }()
+
+	if !aw.headersSent {
+		if !aw.declaresFraming() && !aw.status.IsInformational() && aw.status != StatusCodeNoContent && aw.status != StatusCodeNotModified {
+			aw.header.Set("Content-Length", strconv.Itoa(len(aw.buffer)))
+		}
+		if err := aw.sendHeaders(); err != nil {
+			return err
+		}
+		if len(aw.buffer) > 0 {
+			if _, err := aw.w.WriteBody(aw.buffer); err != nil {
+				return err
+			}
+			aw.buffer = nil
+		}
+	}
+	if !aw.chunked {
+		return nil
+	}
+	if _, err := aw.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return aw.w.WriteTrailers(headers.NewHeaders())
+}
+
+func (aw *AutoWriter) declaresFraming() bool {
+	_, hasLength := aw.header.Get("Content-Length")
+	_, hasEncoding := aw.header.Get("Transfer-Encoding")
+	return hasLength || hasEncoding
+}
+
+func (aw *AutoWriter) startChunked() error {
+	aw.header.Set("Transfer-Encoding", "chunked")
+	if err := aw.sendHeaders(); err != nil {
+		return err
+	}
+	if len(aw.buffer) == 0 {
+		return nil
+	}
+	_, err := aw.w.WriteChunkedBody(aw.buffer)
+	aw.buffer = nil
+	return err
+}
+
+func (aw *AutoWriter) sendHeaders() error {
+	if err := aw.w.WriteStatusLine(aw.status); err != nil {
+		return err
+	}
+	// the headers went out as far as the caller is concerned, even if
+	// writing them failed
+	aw.headersSent = true
+	if value, ok := aw.header.Get("Transfer-Encoding"); ok && isChunked(value) {
+		aw.chunked = true
+	}
+	return aw.w.WriteHeaders(aw.header)
+}
+
+func (aw *AutoWriter) writeBody(p []byte) (int, error) {
+	if aw.chunked {
+		if len(p) == 0 {
+			// an empty chunk would end the body
+			return 0, nil
+		}
+		// report the bytes of p, not the framing around them
+		if _, err := aw.w.WriteChunkedBody(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return aw.w.WriteBody(p)
+}
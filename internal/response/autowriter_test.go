@@ -0,0 +1,136 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoWriterSmallBody(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	aw := NewAutoWriter(&w)
+
+	aw.Header().Set("Content-Type", "text/html")
+	aw.WriteHeader(StatusCodeCreated)
+	_, err := io.WriteString(aw, "<p>")
+	require.NoError(t, err)
+	_, err = io.WriteString(aw, "hi</p>")
+	require.NoError(t, err)
+	assert.Empty(t, buffer.String(), "small bodies are buffered until Close")
+
+	require.NoError(t, aw.Close())
+	assert.Equal(t, "HTTP/1.1 201 Created\r\nContent-Type: text/html\r\nContent-Length: 9\r\n\r\n<p>hi</p>", buffer.String())
+	assert.True(t, w.KeepAlive())
+
+	_, err = aw.Write([]byte("late"))
+	assert.Error(t, err)
+}
+
+func TestAutoWriterImplicitHeader(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	aw := NewAutoWriter(&w)
+
+	_, _ = io.WriteString(aw, "ok")
+	aw.WriteHeader(StatusCodeNotFound)
+	require.NoError(t, aw.Close())
+	assert.True(t, strings.HasPrefix(buffer.String(), "HTTP/1.1 200 OK\r\n"))
+
+	buffer.Reset()
+	w = NewWriter(&buffer)
+	aw = NewAutoWriter(&w)
+	aw.WriteHeader(StatusCodeNoContent)
+	require.NoError(t, aw.Close())
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n\r\n", buffer.String())
+	assert.True(t, w.KeepAlive())
+}
+
+func TestAutoWriterSwitchesToChunked(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	aw := NewAutoWriter(&w)
+	aw.Threshold = 8
+
+	_, _ = io.WriteString(aw, "hello ")
+	assert.Empty(t, buffer.String())
+	_, _ = io.WriteString(aw, "chunked ")
+	_, _ = io.WriteString(aw, "world")
+	require.NoError(t, aw.Close())
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"E\r\nhello chunked \r\n"+
+		"5\r\nworld\r\n"+
+		"0\r\n\r\n", buffer.String())
+	assert.True(t, w.KeepAlive())
+}
+
+func TestAutoWriterCloseDelimitedForHTTP10(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	w.SetRequestVersion(1, 0)
+	aw := NewAutoWriter(&w)
+	aw.Threshold = 4
+
+	_, _ = io.WriteString(aw, "hello ")
+	_, _ = io.WriteString(aw, "world")
+	require.NoError(t, aw.Close())
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nhello world", buffer.String())
+	assert.False(t, w.KeepAlive())
+}
+
+func TestAutoWriterFlush(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	aw := NewAutoWriter(&w)
+
+	_, _ = io.WriteString(aw, "early")
+	require.NoError(t, aw.Flush())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nearly\r\n", buffer.String())
+	require.NoError(t, aw.Close())
+	assert.True(t, strings.HasSuffix(buffer.String(), "0\r\n\r\n"))
+}
+
+func TestAutoWriterDeclaredLength(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	aw := NewAutoWriter(&w)
+	aw.Threshold = 2
+
+	aw.Header().Set("Content-Length", "11")
+	_, _ = io.WriteString(aw, "hello ")
+	assert.Contains(t, buffer.String(), "Content-Length: 11\r\n\r\nhello ")
+	_, _ = io.WriteString(aw, "world")
+	require.NoError(t, aw.Close())
+
+	assert.NotContains(t, buffer.String(), "chunked")
+	assert.True(t, strings.HasSuffix(buffer.String(), "\r\n\r\nhello world"))
+	assert.True(t, w.KeepAlive())
+}
+
+func TestAutoWriterCopy(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	aw := NewAutoWriter(&w)
+
+	body := strings.Repeat("0123456789abcdef", 8*1_024)
+	// hide WriterTo so io.Copy goes through several Write calls
+	n, err := io.Copy(aw, struct{ io.Reader }{strings.NewReader(body)})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(body)), n)
+	require.NoError(t, aw.Close())
+
+	resp, err := http.ReadResponse(bufio.NewReader(&buffer), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	received, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(received))
+}
@@ -0,0 +1,92 @@
+package response
+
+import (
+	"encoding/hex"
+	"errors"
+	"hash"
+	"httpfromtcp/internal/headers"
+	"strconv"
+)
+
+var errDigestWriterClosed = errors.New("write after DigestWriter was closed")
+
+// DigestWriter streams a chunked body while hashing and counting it, then
+// sends the hex digest and the length as trailers. Either trailer name can be
+// empty to leave that trailer out.
+type DigestWriter struct {
+	w             *Writer
+	hash          hash.Hash
+	digestTrailer string
+	lengthTrailer string
+	length        int
+	closed        bool
+
+	// Trailers are sent by Close along with the computed ones. Their names
+	// must be declared in the headers given to WriteHeaders.
+	Trailers *headers.Headers
+}
+
+func NewDigestWriter(w *Writer, hash hash.Hash, digestTrailer, lengthTrailer string) *DigestWriter {
+	return &DigestWriter{
+		w:             w,
+		hash:          hash,
+		digestTrailer: digestTrailer,
+		lengthTrailer: lengthTrailer,
+		Trailers:      headers.NewHeaders(),
+	}
+}
+
+// WriteHeaders sends h with chunked coding, adding the digest and length to
+// the Trailer declaration.
+func (dw *DigestWriter) WriteHeaders(h *headers.Headers) error {
+	h.Del("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	for _, name := range []string{dw.digestTrailer, dw.lengthTrailer} {
+		if name == "" {
+			continue
+		}
+		if declared, ok := h.Get("Trailer"); ok && declared != "" {
+			h.Set("Trailer", declared+", "+name)
+		} else {
+			h.Set("Trailer", name)
+		}
+	}
+	return dw.w.WriteHeaders(h)
+}
+
+// Write sends p as one chunk. Empty writes send nothing, since an empty
+// chunk would end the body.
+func (dw *DigestWriter) Write(p []byte) (int, error) {
+	if dw.closed {
+		return 0, errDigestWriterClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := dw.w.WriteChunkedBody(p); err != nil {
+		return 0, err
+	}
+	dw.hash.Write(p)
+	dw.length += len(p)
+	return len(p), nil
+}
+
+// Close ends the body and sends the trailers.
+func (dw *DigestWriter) Close() error {
+	if dw.closed {
+		return nil
+	}
+	dw.closed = true
+	if _, err := dw.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+
+	trailers := dw.Trailers.Clone()
+	if dw.digestTrailer != "" {
+		trailers.Set(dw.digestTrailer, hex.EncodeToString(dw.hash.Sum(nil)))
+	}
+	if dw.lengthTrailer != "" {
+		trailers.Set(dw.lengthTrailer, strconv.Itoa(dw.length))
+	}
+	return dw.w.WriteTrailers(trailers)
+}
@@ -0,0 +1,117 @@
+package response
+
+import (
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"io"
+	"strconv"
+)
+
+// EncodeFunc chooses a content coding for a response from its status and the
+// headers the handler is about to send, which it may adjust. It returns the
+// coding's name and a constructor for the compressor, or an empty name to
+// send the body as is.
+type EncodeFunc func(status StatusCode, h *headers.Headers) (coding string, newEncoder func(io.Writer) io.WriteCloser)
+
+// SetEncoder makes WriteHeaders consult choose before sending the headers.
+// When it picks a coding, the writer sets Content-Encoding, drops the
+// Content-Length and sends the compressed body with chunked coding, while
+// the handler keeps writing the body it declared.
+func (w *Writer) SetEncoder(choose EncodeFunc) {
+	w.choose = choose
+}
+
+// encodedBody holds what the handler declared about a body the writer
+// compresses.
+type encodedBody struct {
+	encoder       io.WriteCloser
+	chunked       bool
+	contentLength int
+	written       int
+}
+
+func (w *Writer) startEncoding(h *headers.Headers) {
+	if w.choose == nil {
+		return
+	}
+	coding, newEncoder := w.choose(w.statusCode, h)
+	if coding == "" {
+		return
+	}
+
+	body := &encodedBody{contentLength: -1}
+	if value, ok := h.Get("Transfer-Encoding"); ok && isChunked(value) {
+		body.chunked = true
+	} else if value, ok := h.Get("Content-Length"); ok {
+		if contentLength, err := strconv.Atoi(value); err == nil && contentLength >= 0 {
+			body.contentLength = contentLength
+		}
+	}
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", coding)
+	h.Set("Transfer-Encoding", "chunked")
+	body.encoder = newEncoder(chunkWriter{w})
+	w.encoded = body
+}
+
+// writeEncoded compresses part of a body the handler sends with WriteBody,
+// ending the response once its declared Content-Length is reached.
+func (w *Writer) writeEncoded(p []byte) (int, error) {
+	body := w.encoded
+	if body.chunked {
+		return 0, fmt.Errorf("WriteBody on a chunked response, use WriteChunkedBody")
+	}
+	if body.contentLength >= 0 && body.written+len(p) > body.contentLength {
+		return 0, fmt.Errorf("%w: %d more bytes after %d of %d", ErrContentLengthExceeded, len(p), body.written, body.contentLength)
+	}
+	n, err := body.encoder.Write(p)
+	body.written += n
+	if err != nil {
+		return n, err
+	}
+	if body.contentLength < 0 || body.written >= body.contentLength {
+		return n, w.finishEncoded()
+	}
+	return n, nil
+}
+
+// writeEncodedChunk compresses a chunk and flushes the compressor, so
+// streamed responses still reach the client as they are written.
+func (w *Writer) writeEncodedChunk(p []byte) (int, error) {
+	body := w.encoded
+	if !body.chunked {
+		return 0, fmt.Errorf("WriteChunkedBody without Transfer-Encoding: chunked")
+	}
+	n, err := body.encoder.Write(p)
+	body.written += n
+	if err != nil {
+		return n, err
+	}
+	if flusher, ok := body.encoder.(interface{ Flush() error }); ok {
+		return n, flusher.Flush()
+	}
+	return n, nil
+}
+
+func (w *Writer) finishEncoded() error {
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return w.WriteTrailers(headers.NewHeaders())
+}
+
+// chunkWriter sends what a compressor writes as chunks of the body.
+type chunkWriter struct {
+	w *Writer
+}
+
+func (cw chunkWriter) Write(p []byte) (int, error) {
+	if cw.w.bodyless() || len(p) == 0 {
+		// an empty chunk would end the body
+		return len(p), nil
+	}
+	if _, err := cw.w.writeChunk(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -1,18 +1,14 @@
 package response
 
 import (
+	"errors"
 	"fmt"
 	"httpfromtcp/internal/headers"
 	"io"
 	"log"
-)
-
-type StatusCode int
-
-const (
-	StatusCodeOK                  StatusCode = 200
-	StatusCodeBadRequest          StatusCode = 400
-	StatusCodeInternalServerError StatusCode = 500
+	"slices"
+	"strconv"
+	"strings"
 )
 
 type writerState int
@@ -27,54 +23,188 @@ const (
 
 const CRLF = "\r\n"
 
+var (
+	// ErrContentLengthExceeded is returned by a body write that would go
+	// past the declared Content-Length. Nothing is written.
+	ErrContentLengthExceeded = errors.New("body exceeds the declared Content-Length")
+	// ErrIncompleteResponse is returned by Complete when the response
+	// stopped short of what its headers announced.
+	ErrIncompleteResponse = errors.New("incomplete response")
+)
+
 type Writer struct {
 	io.Writer
 	state writerState
+
+	statusCode      StatusCode
+	extraHeaders    *headers.Headers
+	closeConnection bool
+	chunked         bool
+	contentLength   int
+	bodyBytes       int
+	http10          bool
+	closeDelimited  bool
+	head            bool
+	// trailers holds the lower-cased names declared by the Trailer header
+	trailers []string
+	choose   EncodeFunc
+	// encoded is set while the body is compressed
+	encoded *encodedBody
 }
 
 func NewWriter(w io.Writer) Writer {
-	return Writer{w, writerStateStatusLine}
+	return Writer{Writer: w, state: writerStateStatusLine, contentLength: -1}
+}
+
+// CloseAfterResponse makes WriteHeaders announce "Connection: close" so the
+// client does not send further requests on this connection.
+func (w *Writer) CloseAfterResponse() {
+	w.closeConnection = true
+}
+
+// SetRequestVersion tells the writer which HTTP version the client speaks.
+// HTTP/1.0 clients get "Connection: keep-alive" on reusable responses, and
+// chunked responses are sent to them as a body delimited by closing the
+// connection, since they cannot decode chunked coding.
+func (w *Writer) SetRequestVersion(major, minor int) {
+	w.http10 = major < 1 || (major == 1 && minor == 0)
+}
+
+// SetRequestMethod tells the writer which method the response answers. The
+// body of a response to HEAD is discarded, since the client expects none.
+func (w *Writer) SetRequestMethod(method string) {
+	w.head = method == "HEAD"
+}
+
+// SetHeader queues a header that WriteHeaders adds to the response, for code
+// that wraps a handler and cannot touch the headers it writes.
+func (w *Writer) SetHeader(key, value string) {
+	if w.extraHeaders == nil {
+		w.extraHeaders = headers.NewHeaders()
+	}
+	w.extraHeaders.Set(key, value)
+}
+
+// Status returns the status code written so far, or 0 before the status line.
+func (w *Writer) Status() StatusCode {
+	return w.statusCode
+}
+
+// BodyBytes returns the number of body bytes written so far.
+func (w *Writer) BodyBytes() int {
+	return w.bodyBytes
+}
+
+// KeepAlive reports whether the response was completely written with a
+// framing the client can delimit, so the connection can carry another request.
+func (w *Writer) KeepAlive() bool {
+	if w.closeConnection {
+		return false
+	}
+	switch {
+	case w.bodyless():
+		return w.state >= writerStateBody
+	case w.chunked:
+		return w.state == writerStateDone
+	case w.contentLength >= 0:
+		return w.state >= writerStateBody && w.bodyBytes == w.contentLength
+	default:
+		return false
+	}
+}
+
+// Complete returns ErrIncompleteResponse unless the whole response was
+// written: the headers, then a body matching its framing.
+func (w *Writer) Complete() error {
+	switch {
+	case w.state < writerStateBody:
+		return fmt.Errorf("%w: headers not written", ErrIncompleteResponse)
+	case w.bodyless():
+		return nil
+	case w.chunked && w.state != writerStateDone:
+		return fmt.Errorf("%w: chunked body not terminated", ErrIncompleteResponse)
+	case w.contentLength >= 0 && w.bodyBytes < w.contentLength:
+		return fmt.Errorf("%w: wrote %d of %d body bytes", ErrIncompleteResponse, w.bodyBytes, w.contentLength)
+	default:
+		return nil
+	}
 }
 
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineWithReason(statusCode, StatusText(statusCode))
+}
+
+// WriteStatusLineWithReason writes a status line with a custom reason phrase.
+func (w *Writer) WriteStatusLineWithReason(statusCode StatusCode, reasonPhrase string) error {
 	if w.state != writerStateStatusLine {
 		return fmt.Errorf("invalid state: %v", w.state)
 	}
-
-	var reasonPhrase string
-	switch statusCode {
-	case StatusCodeOK:
-		reasonPhrase = "OK"
-	case StatusCodeBadRequest:
-		reasonPhrase = "Bad Request"
-	case StatusCodeInternalServerError:
-		reasonPhrase = "Internal Server Error"
-	default:
-		reasonPhrase = ""
+	if err := validateStatus(statusCode); err != nil {
+		return err
+	}
+	if strings.ContainsFunc(reasonPhrase, invalidReasonRune) {
+		return fmt.Errorf("invalid reason phrase %q", reasonPhrase)
 	}
 
 	statusLine := fmt.Sprintf("HTTP/1.1 %d %s%s", statusCode, reasonPhrase, CRLF)
 
 	// log.Println(statusLine)
 	_, err := w.Write([]byte(statusLine))
+	w.statusCode = statusCode
 	w.state = writerStateHeaders
 	return err
 }
 
-func GetDefaultHeaders(contentLen int) headers.Headers {
+// invalidReasonRune rejects control characters other than horizontal tab,
+// which would let a reason phrase break out of the status line.
+func invalidReasonRune(r rune) bool {
+	return r != '\t' && (r < ' ' || r == 0x7f)
+}
+
+func GetDefaultHeaders(contentLen int) *headers.Headers {
 	h := headers.NewHeaders()
 	h.Set("Content-Length", fmt.Sprintf("%d", contentLen))
-	h.Set("Connection", "close")
 	h.Set("Content-Type", "text/plain")
 
 	return h
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
+func (w *Writer) WriteHeaders(headers *headers.Headers) error {
 	if w.state != writerStateHeaders {
 		return fmt.Errorf("invalid state %v", w.state)
 	}
-	for key, value := range headers {
+
+	if w.extraHeaders != nil {
+		for key, value := range w.extraHeaders.All() {
+			headers.Set(key, value)
+		}
+	}
+	if err := validateFields(headers); err != nil {
+		return err
+	}
+	trailers, err := declaredTrailers(headers)
+	if err != nil {
+		return err
+	}
+	w.trailers = trailers
+	if !w.statusCode.IsInformational() && w.statusCode != StatusCodeNoContent && w.statusCode != StatusCodeNotModified {
+		w.startEncoding(headers)
+	}
+	if value, ok := headers.Get("Transfer-Encoding"); ok && w.http10 && isChunked(value) {
+		headers.Del("Transfer-Encoding")
+		headers.Del("Trailer")
+		headers.Del("Content-Length")
+		w.closeDelimited = true
+		w.closeConnection = true
+	}
+	w.trackFraming(headers)
+	if w.closeConnection {
+		headers.Set("Connection", "close")
+	} else if w.http10 && w.contentLength >= 0 {
+		headers.Set("Connection", "keep-alive")
+	}
+
+	for key, value := range headers.All() {
 
 		line := fmt.Sprintf("%s: %s%s", key, value, CRLF)
 		log.Println(line)
@@ -89,15 +219,36 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	}
 
 	w.state = writerStateBody
+	if w.encoded != nil && w.encoded.contentLength == 0 {
+		return w.finishEncoded()
+	}
 	return nil
 }
 
+// WriteBody writes part of the body. With a Content-Length it can be called
+// until that many bytes are written, and refuses writes going past it;
+// otherwise the first call completes the response.
 func (w *Writer) WriteBody(p []byte) (int, error) {
 	if w.state != writerStateBody {
 		return 0, fmt.Errorf("invalid state %v", w.state)
 	}
+	if w.bodyless() {
+		return len(p), nil
+	}
+	if w.encoded != nil {
+		return w.writeEncoded(p)
+	}
+	if w.chunked {
+		return 0, fmt.Errorf("WriteBody on a chunked response, use WriteChunkedBody")
+	}
+	if w.contentLength >= 0 && w.bodyBytes+len(p) > w.contentLength {
+		return 0, fmt.Errorf("%w: %d more bytes after %d of %d", ErrContentLengthExceeded, len(p), w.bodyBytes, w.contentLength)
+	}
 	n, err := w.Write(p)
-	w.state = writerStateDone
+	w.bodyBytes += n
+	if w.contentLength < 0 || w.bodyBytes >= w.contentLength {
+		w.state = writerStateDone
+	}
 	return n, err
 }
 
@@ -106,7 +257,26 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 		return 0, fmt.Errorf("invalid state %v", w.state)
 	}
 
+	if w.bodyless() {
+		return len(p), nil
+	}
+	if w.encoded != nil {
+		return w.writeEncodedChunk(p)
+	}
+	if !w.chunked && !w.closeDelimited {
+		return 0, fmt.Errorf("WriteChunkedBody without Transfer-Encoding: chunked")
+	}
+	return w.writeChunk(p)
+}
+
+// writeChunk frames p as a chunk, or writes it as is to a close-delimited
+// body.
+func (w *Writer) writeChunk(p []byte) (int, error) {
 	n := len(p)
+	w.bodyBytes += n
+	if w.closeDelimited {
+		return w.Write(p)
+	}
 	return w.Write([]byte(fmt.Sprintf("%X%s%s%s", n, CRLF, p, CRLF)))
 }
 
@@ -115,16 +285,42 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 		return 0, fmt.Errorf("invalid state %v", w.state)
 	}
 
+	if w.encoded != nil {
+		if err := w.encoded.encoder.Close(); err != nil {
+			return 0, err
+		}
+	}
 	w.state = writerStateTrailers
+	if w.closeDelimited || w.bodyless() {
+		return 0, nil
+	}
 	return w.Write([]byte(fmt.Sprintf("0%s", CRLF)))
 }
 
-func (w *Writer) WriteTrailers(headers headers.Headers) error {
+// WriteTrailers ends a chunked body with trailer fields, each of which must
+// have been declared by the Trailer header.
+func (w *Writer) WriteTrailers(headers *headers.Headers) error {
 	if w.state != writerStateTrailers {
 		return fmt.Errorf("invalid state %v", w.state)
 	}
+	if err := validateFields(headers); err != nil {
+		return err
+	}
+	for key := range headers.All() {
+		if !ValidTrailerField(key) {
+			return fmt.Errorf("%w: %q", ErrForbiddenTrailer, key)
+		}
+		if !slices.Contains(w.trailers, strings.ToLower(key)) {
+			return fmt.Errorf("%w: %q", ErrUndeclaredTrailer, key)
+		}
+	}
+	if w.closeDelimited || w.bodyless() {
+		// there is nowhere to put trailers without chunked coding
+		w.state = writerStateDone
+		return nil
+	}
 
-	for key, value := range headers {
+	for key, value := range headers.All() {
 
 		line := fmt.Sprintf("%s: %s%s", key, value, CRLF)
 		log.Println(line)
@@ -141,3 +337,44 @@ func (w *Writer) WriteTrailers(headers headers.Headers) error {
 	w.state = writerStateDone
 	return nil
 }
+
+// validateFields refuses names and values that would let a handler inject
+// extra lines into the response.
+func validateFields(fields *headers.Headers) error {
+	for key, value := range fields.All() {
+		if !headers.ValidFieldName(key) {
+			return fmt.Errorf("%w: %q", headers.ErrInvalidFieldName, key)
+		}
+		if !headers.ValidFieldValue(value) {
+			return fmt.Errorf("%w: value of %s contains control characters", headers.ErrInvalidFieldValue, key)
+		}
+	}
+	return nil
+}
+
+// trackFraming records how the body is delimited so KeepAlive can tell
+// whether the client will find the end of the response.
+func (w *Writer) trackFraming(headers *headers.Headers) {
+	if value, ok := headers.Get("Connection"); ok && strings.EqualFold(strings.TrimSpace(value), "close") {
+		w.closeConnection = true
+	}
+	if value, ok := headers.Get("Transfer-Encoding"); ok && isChunked(value) {
+		w.chunked = true
+		return
+	}
+	if value, ok := headers.Get("Content-Length"); ok {
+		if contentLength, err := strconv.Atoi(value); err == nil && contentLength >= 0 {
+			w.contentLength = contentLength
+		}
+	}
+}
+
+// bodyless reports whether the response can have no body whatever its
+// headers say: answers to HEAD and 1xx, 204 and 304 responses.
+func (w *Writer) bodyless() bool {
+	return w.head || w.statusCode.IsInformational() || w.statusCode == StatusCodeNoContent || w.statusCode == StatusCodeNotModified
+}
+
+func isChunked(transferEncoding string) bool {
+	return strings.EqualFold(strings.TrimSpace(transferEncoding), "chunked")
+}
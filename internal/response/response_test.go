@@ -0,0 +1,211 @@
+package response
+
+import (
+	"bytes"
+	"httpfromtcp/internal/headers"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteHeadersKeepsOrderAndRepeatedFields(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/plain")
+	h.Add("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+	h.Add("Set-Cookie", "b=2")
+	h.Set("Content-Length", "0")
+
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Set-Cookie: a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT\r\n"+
+		"Set-Cookie: b=2\r\n"+
+		"Content-Length: 0\r\n"+
+		"\r\n", buffer.String())
+}
+
+func TestWriteHeadersRejectsInjection(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	statusLine := buffer.String()
+
+	h := headers.NewHeaders()
+	h.Set("Location", "/ok\r\nSet-Cookie: admin=true")
+	assert.ErrorIs(t, w.WriteHeaders(h), headers.ErrInvalidFieldValue)
+
+	h = headers.NewHeaders()
+	h.Set("X-Bad\r\nName", "value")
+	assert.ErrorIs(t, w.WriteHeaders(h), headers.ErrInvalidFieldName)
+
+	assert.Equal(t, statusLine, buffer.String())
+}
+
+func TestWriteBodyInParts(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(11)))
+
+	_, err := w.WriteBody([]byte("hello "))
+	require.NoError(t, err)
+	assert.False(t, w.KeepAlive())
+	_, err = w.WriteBody([]byte("world"))
+	require.NoError(t, err)
+	assert.True(t, w.KeepAlive())
+
+	_, err = w.WriteBody([]byte("!"))
+	assert.Error(t, err)
+	assert.True(t, bytes.HasSuffix(buffer.Bytes(), []byte("\r\n\r\nhello world")))
+}
+
+func TestBodylessResponses(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	w.SetRequestMethod("HEAD")
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	n, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.True(t, bytes.HasSuffix(buffer.Bytes(), []byte("Content-Length: 5\r\nContent-Type: text/plain\r\n\r\n")))
+	assert.True(t, w.KeepAlive())
+
+	buffer.Reset()
+	w = NewWriter(&buffer)
+	require.NoError(t, w.WriteStatusLine(StatusCodeNotModified))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.True(t, w.KeepAlive())
+}
+
+func TestWriteBodyEnforcesContentLength(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	headerLength := buffer.Len()
+
+	_, err := w.WriteBody([]byte("hello world"))
+	assert.ErrorIs(t, err, ErrContentLengthExceeded)
+	assert.Equal(t, headerLength, buffer.Len(), "nothing is written past the declared length")
+
+	_, err = w.WriteBody([]byte("hel"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, w.Complete(), ErrIncompleteResponse)
+	assert.False(t, w.KeepAlive())
+
+	_, err = w.WriteBody([]byte("lo"))
+	require.NoError(t, err)
+	assert.NoError(t, w.Complete())
+}
+
+func TestCompleteChunked(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	assert.ErrorIs(t, w.Complete(), ErrIncompleteResponse)
+
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(h))
+
+	_, err := w.WriteBody([]byte("raw"))
+	assert.Error(t, err)
+	_, err = w.WriteChunkedBody([]byte("data"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.ErrorIs(t, w.Complete(), ErrIncompleteResponse)
+	require.NoError(t, w.WriteTrailers(headers.NewHeaders()))
+	assert.NoError(t, w.Complete())
+}
+
+func TestWriteChunkedBodyRequiresChunkedFraming(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(4)))
+
+	_, err := w.WriteChunkedBody([]byte("data"))
+	assert.Error(t, err)
+}
+
+type upperEncoder struct {
+	io.Writer
+}
+
+func (e upperEncoder) Write(p []byte) (int, error) {
+	return e.Writer.Write(bytes.ToUpper(p))
+}
+
+func (e upperEncoder) Close() error {
+	_, err := e.Writer.Write([]byte("!"))
+	return err
+}
+
+func upper(status StatusCode, h *headers.Headers) (string, func(io.Writer) io.WriteCloser) {
+	return "upper", func(w io.Writer) io.WriteCloser { return upperEncoder{w} }
+}
+
+func TestSetEncoder(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	w.SetEncoder(upper)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+
+	_, err := w.WriteBody([]byte("hello world"))
+	assert.ErrorIs(t, err, ErrContentLengthExceeded)
+	_, err = w.WriteBody([]byte("hel"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, w.Complete(), ErrIncompleteResponse)
+	_, err = w.WriteBody([]byte("lo"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Content-Encoding: upper\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"\r\n"+
+		"3\r\nHEL\r\n"+
+		"2\r\nLO\r\n"+
+		"1\r\n!\r\n"+
+		"0\r\n\r\n", buffer.String())
+	assert.NoError(t, w.Complete())
+	assert.True(t, w.KeepAlive())
+}
+
+func TestSetEncoderHTTP10(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	w.SetRequestVersion(1, 0)
+	w.SetEncoder(upper)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(2)))
+	_, err := w.WriteBody([]byte("hi"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Content-Encoding: upper\r\n"+
+		"Connection: close\r\n"+
+		"\r\n"+
+		"HI!", buffer.String())
+	assert.NoError(t, w.Complete())
+	assert.False(t, w.KeepAlive())
+}
+
+func TestSetEncoderSkipsNotModified(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	w.SetEncoder(upper)
+	require.NoError(t, w.WriteStatusLine(StatusCodeNotModified))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\n\r\n", buffer.String())
+}
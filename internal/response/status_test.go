@@ -0,0 +1,47 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusText(t *testing.T) {
+	assert.Equal(t, "Not Found", StatusText(StatusCodeNotFound))
+	assert.Equal(t, "HTTP Version Not Supported", StatusText(StatusCodeHTTPVersionNotSupported))
+	assert.Equal(t, "", StatusText(599))
+}
+
+func TestStatusClasses(t *testing.T) {
+	assert.True(t, StatusCodeContinue.IsInformational())
+	assert.True(t, StatusCodeNoContent.IsSuccess())
+	assert.True(t, StatusCodeSeeOther.IsRedirection())
+	assert.True(t, StatusCodeTooManyRequests.IsClientError())
+	assert.True(t, StatusCodeBadGateway.IsServerError())
+	assert.False(t, StatusCodeOK.IsClientError())
+}
+
+func TestWriteStatusLine(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	require.NoError(t, w.WriteStatusLine(StatusCodeNotFound))
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\n", buffer.String())
+}
+
+func TestWriteStatusLineWithReason(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	require.NoError(t, w.WriteStatusLineWithReason(299, "Custom Thing"))
+	assert.Equal(t, "HTTP/1.1 299 Custom Thing\r\n", buffer.String())
+}
+
+func TestWriteStatusLineRejectsInvalid(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	assert.Error(t, w.WriteStatusLine(99))
+	assert.Error(t, w.WriteStatusLine(1000))
+	assert.Error(t, w.WriteStatusLineWithReason(StatusCodeOK, "OK\r\nSet-Cookie: x=y"))
+	assert.Empty(t, buffer.String())
+}
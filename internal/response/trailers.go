@@ -0,0 +1,74 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"strings"
+)
+
+var (
+	ErrForbiddenTrailer  = errors.New("field not allowed in trailers")
+	ErrUndeclaredTrailer = errors.New("trailer not declared in the Trailer header")
+)
+
+// forbiddenTrailers are the fields RFC 9110 section 6.5.1 keeps out of
+// trailers, because recipients need them before the body: framing, routing,
+// request modifiers, authentication, response control data and content
+// processing.
+var forbiddenTrailers = map[string]bool{
+	"transfer-encoding":   true,
+	"content-length":      true,
+	"trailer":             true,
+	"host":                true,
+	"cache-control":       true,
+	"expect":              true,
+	"max-forwards":        true,
+	"pragma":              true,
+	"range":               true,
+	"te":                  true,
+	"if-match":            true,
+	"if-none-match":       true,
+	"if-modified-since":   true,
+	"if-unmodified-since": true,
+	"if-range":            true,
+	"authorization":       true,
+	"proxy-authenticate":  true,
+	"proxy-authorization": true,
+	"set-cookie":          true,
+	"www-authenticate":    true,
+	"age":                 true,
+	"date":                true,
+	"expires":             true,
+	"location":            true,
+	"retry-after":         true,
+	"vary":                true,
+	"warning":             true,
+	"content-encoding":    true,
+	"content-type":        true,
+	"content-range":       true,
+}
+
+// ValidTrailerField reports whether name may be sent as a trailer.
+func ValidTrailerField(name string) bool {
+	return headers.ValidFieldName(name) && !forbiddenTrailers[strings.ToLower(name)]
+}
+
+// declaredTrailers returns the lower-cased names listed by the Trailer
+// header, refusing fields that cannot be trailers.
+func declaredTrailers(h *headers.Headers) ([]string, error) {
+	var declared []string
+	for _, value := range h.Values("Trailer") {
+		for name := range strings.SplitSeq(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if !ValidTrailerField(name) {
+				return nil, fmt.Errorf("%w: %q", ErrForbiddenTrailer, name)
+			}
+			declared = append(declared, strings.ToLower(name))
+		}
+	}
+	return declared, nil
+}
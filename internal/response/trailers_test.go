@@ -0,0 +1,107 @@
+package response
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func chunkedWriter(t *testing.T, buffer *bytes.Buffer, trailer string) *Writer {
+	t.Helper()
+	w := NewWriter(buffer)
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	if trailer != "" {
+		h.Set("Trailer", trailer)
+	}
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	return &w
+}
+
+func TestTrailersMustBeDeclared(t *testing.T) {
+	var buffer bytes.Buffer
+	w := chunkedWriter(t, &buffer, "X-Checksum, x-count")
+
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Other", "1")
+	assert.ErrorIs(t, w.WriteTrailers(trailers), ErrUndeclaredTrailer)
+
+	trailers = headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+	trailers.Set("X-Count", "1")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.True(t, bytes.HasSuffix(buffer.Bytes(), []byte("0\r\nX-Checksum: abc\r\nX-Count: 1\r\n\r\n")))
+
+	buffer.Reset()
+	w = chunkedWriter(t, &buffer, "")
+	trailers = headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+	assert.ErrorIs(t, w.WriteTrailers(trailers), ErrUndeclaredTrailer)
+	assert.NoError(t, w.WriteTrailers(headers.NewHeaders()))
+}
+
+func TestForbiddenTrailers(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Checksum, Content-Length")
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+	assert.ErrorIs(t, w.WriteHeaders(h), ErrForbiddenTrailer)
+
+	buffer.Reset()
+	cw := chunkedWriter(t, &buffer, "X-Checksum")
+	trailers := headers.NewHeaders()
+	trailers.Set("Transfer-Encoding", "chunked")
+	assert.ErrorIs(t, cw.WriteTrailers(trailers), ErrForbiddenTrailer)
+
+	assert.True(t, ValidTrailerField("Server-Timing"))
+	assert.False(t, ValidTrailerField("set-cookie"))
+	assert.False(t, ValidTrailerField("bad name"))
+}
+
+func TestDigestWriter(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	require.NoError(t, w.WriteStatusLine(StatusCodeOK))
+
+	dw := NewDigestWriter(&w, sha256.New(), "X-Content-Sha256", "X-Content-Length")
+	h := GetDefaultHeaders(0)
+	h.Set("Trailer", "X-Upstream")
+	require.NoError(t, dw.WriteHeaders(h))
+	_, err := io.WriteString(dw, "hello ")
+	require.NoError(t, err)
+	_, err = dw.Write(nil)
+	require.NoError(t, err)
+	_, err = io.WriteString(dw, "world")
+	require.NoError(t, err)
+	dw.Trailers.Set("X-Upstream", "yes")
+	require.NoError(t, dw.Close())
+
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte("hello world")))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Trailer: X-Upstream, X-Content-Sha256, X-Content-Length\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"\r\n"+
+		"6\r\nhello \r\n"+
+		"5\r\nworld\r\n"+
+		"0\r\n"+
+		"X-Upstream: yes\r\n"+
+		"X-Content-Sha256: "+sum+"\r\n"+
+		"X-Content-Length: 11\r\n"+
+		"\r\n", buffer.String())
+	assert.NoError(t, w.Complete())
+
+	_, err = dw.Write([]byte("late"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,285 @@
+package router
+
+import (
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const WILDCARD = "*"
+
+type segmentKind int
+
+const (
+	segmentKindWildcard segmentKind = iota
+	segmentKindParam
+	segmentKindStatic
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+type route struct {
+	method   string
+	segments []segment
+	handler  server.Handler
+}
+
+// Router dispatches requests to handlers by method and path. Router.Route is
+// a server.Handler.
+type Router struct {
+	routes      []route
+	middlewares []server.Middleware
+	// NotFound answers requests whose path matches no route.
+	NotFound server.Handler
+}
+
+// Group registers routes under a shared path prefix and middlewares.
+type Group struct {
+	router      *Router
+	prefix      string
+	middlewares []server.Middleware
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for method and pattern. An empty method matches
+// any method. Pattern segments can be static, a {name} parameter or a
+// trailing * wildcard that captures the rest of the path.
+func (rt *Router) Handle(method, pattern string, handler server.Handler) {
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	rt.routes = append(rt.routes, route{method: method, segments: segments, handler: handler})
+}
+
+func (rt *Router) Any(pattern string, handler server.Handler) {
+	rt.Handle("", pattern, handler)
+}
+
+func (rt *Router) Get(pattern string, handler server.Handler) {
+	rt.Handle("GET", pattern, handler)
+}
+
+func (rt *Router) Post(pattern string, handler server.Handler) {
+	rt.Handle("POST", pattern, handler)
+}
+
+func (rt *Router) Put(pattern string, handler server.Handler) {
+	rt.Handle("PUT", pattern, handler)
+}
+
+func (rt *Router) Patch(pattern string, handler server.Handler) {
+	rt.Handle("PATCH", pattern, handler)
+}
+
+func (rt *Router) Delete(pattern string, handler server.Handler) {
+	rt.Handle("DELETE", pattern, handler)
+}
+
+// Use adds middlewares that wrap every request the router dispatches,
+// including 404 and 405 answers.
+func (rt *Router) Use(middlewares ...server.Middleware) {
+	rt.middlewares = append(rt.middlewares, middlewares...)
+}
+
+func (rt *Router) Group(prefix string) *Group {
+	return &Group{router: rt, prefix: strings.TrimRight(prefix, "/")}
+}
+
+// Use adds middlewares to the routes registered on the group afterwards.
+func (g *Group) Use(middlewares ...server.Middleware) {
+	g.middlewares = append(g.middlewares, middlewares...)
+}
+
+func (g *Group) Handle(method, pattern string, handler server.Handler) {
+	g.router.Handle(method, g.prefix+pattern, server.Chain(handler, g.middlewares...))
+}
+
+func (g *Group) Any(pattern string, handler server.Handler) {
+	g.Handle("", pattern, handler)
+}
+
+func (g *Group) Get(pattern string, handler server.Handler) {
+	g.Handle("GET", pattern, handler)
+}
+
+func (g *Group) Post(pattern string, handler server.Handler) {
+	g.Handle("POST", pattern, handler)
+}
+
+func (g *Group) Put(pattern string, handler server.Handler) {
+	g.Handle("PUT", pattern, handler)
+}
+
+func (g *Group) Patch(pattern string, handler server.Handler) {
+	g.Handle("PATCH", pattern, handler)
+}
+
+func (g *Group) Delete(pattern string, handler server.Handler) {
+	g.Handle("DELETE", pattern, handler)
+}
+
+func (g *Group) Group(prefix string) *Group {
+	return &Group{
+		router:      g.router,
+		prefix:      g.prefix + strings.TrimRight(prefix, "/"),
+		middlewares: slices.Clone(g.middlewares),
+	}
+}
+
+// Route dispatches req to the most specific matching route, answering 404
+// when no route matches the path and 405 when none allows the method.
+func (rt *Router) Route(w *response.Writer, req *request.Request) {
+	server.Chain(rt.route, rt.middlewares...)(w, req)
+}
+
+func (rt *Router) route(w *response.Writer, req *request.Request) {
+	pathSegments := decodedSegments(req.RequestLine.RawPath)
+
+	var best *route
+	var bestParams map[string]string
+	allowed := []string{}
+	for i := range rt.routes {
+		candidate := &rt.routes[i]
+		params, ok := candidate.match(pathSegments)
+		if !ok {
+			continue
+		}
+		if candidate.method != "" && candidate.method != req.RequestLine.Method {
+			if !slices.Contains(allowed, candidate.method) {
+				allowed = append(allowed, candidate.method)
+			}
+			continue
+		}
+		if best == nil || candidate.moreSpecificThan(best) {
+			best = candidate
+			bestParams = params
+		}
+	}
+
+	switch {
+	case best != nil:
+		req.Params = bestParams
+		best.handler(w, req)
+	case len(allowed) > 0:
+		slices.Sort(allowed)
+		methodNotAllowed(w, allowed)
+	case rt.NotFound != nil:
+		rt.NotFound(w, req)
+	default:
+		server.HandlerError{
+			Status:  response.StatusCodeNotFound,
+			Message: "Not Found",
+		}.WriteError(w)
+	}
+}
+
+func methodNotAllowed(w *response.Writer, allowed []string) {
+	body := []byte("Method Not Allowed")
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("Allow", strings.Join(allowed, ", "))
+
+	_ = w.WriteStatusLine(response.StatusCodeMethodNotAllowed)
+	_ = w.WriteHeaders(h)
+	_, _ = w.WriteBody(body)
+}
+
+func (r *route) match(pathSegments []string) (map[string]string, bool) {
+	params := map[string]string{}
+	for i, s := range r.segments {
+		if s.kind == segmentKindWildcard {
+			params[WILDCARD] = strings.Join(pathSegments[i:], "/")
+			return params, true
+		}
+		if i >= len(pathSegments) {
+			return nil, false
+		}
+		switch s.kind {
+		case segmentKindStatic:
+			if s.value != pathSegments[i] {
+				return nil, false
+			}
+		case segmentKindParam:
+			if pathSegments[i] == "" {
+				return nil, false
+			}
+			params[s.value] = pathSegments[i]
+		}
+	}
+	if len(r.segments) != len(pathSegments) {
+		return nil, false
+	}
+	return params, true
+}
+
+// moreSpecificThan prefers static segments over parameters over wildcards,
+// comparing from the start of the path, and method-specific routes on ties.
+func (r *route) moreSpecificThan(other *route) bool {
+	for i := 0; i < len(r.segments) && i < len(other.segments); i++ {
+		if r.segments[i].kind != other.segments[i].kind {
+			return r.segments[i].kind > other.segments[i].kind
+		}
+	}
+	if len(r.segments) != len(other.segments) {
+		// the longer route only matched through an empty trailing wildcard
+		return len(r.segments) < len(other.segments)
+	}
+	return r.method != "" && other.method == ""
+}
+
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("route pattern %q must start with /", pattern)
+	}
+
+	parts := splitPath(pattern)
+	segments := make([]segment, 0, len(parts))
+	for i, part := range parts {
+		switch {
+		case part == WILDCARD:
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("route pattern %q has a wildcard before its last segment", pattern)
+			}
+			segments = append(segments, segment{kind: segmentKindWildcard})
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name := part[1 : len(part)-1]
+			if name == "" {
+				return nil, fmt.Errorf("route pattern %q has an unnamed parameter", pattern)
+			}
+			segments = append(segments, segment{kind: segmentKindParam, value: name})
+		default:
+			segments = append(segments, segment{kind: segmentKindStatic, value: part})
+		}
+	}
+	return segments, nil
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+// decodedSegments splits the path as sent before decoding each segment, so
+// that an encoded slash stays part of its segment.
+func decodedSegments(rawPath string) []string {
+	segments := splitPath(rawPath)
+	for i, segment := range segments {
+		if decoded, err := url.PathUnescape(segment); err == nil {
+			segments[i] = decoded
+		}
+	}
+	return segments
+}
@@ -0,0 +1,145 @@
+package router
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"httpfromtcp/internal/testutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dispatch routes a request and returns the response along with the request,
+// which holds the captured params.
+func dispatch(t *testing.T, rt *Router, method, target string) (string, *request.Request) {
+	t.Helper()
+	req := testutil.ParseRequest(t, testutil.RawRequest(method, target))
+	return testutil.ServeRequest(t, rt.Route, req), req
+}
+
+func TestStaticRoute(t *testing.T) {
+	rt := New()
+	rt.Get("/video", testutil.Named("video"))
+	rt.Any("/*", testutil.Named("fallback"))
+
+	out, _ := dispatch(t, rt, "GET", "/video?quality=high")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nvideo"))
+
+	out, _ = dispatch(t, rt, "POST", "/video")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nfallback"))
+}
+
+func TestPathParams(t *testing.T) {
+	rt := New()
+	rt.Get("/users/{id}", testutil.Named("user"))
+	rt.Get("/users/{id}/posts/{post}", testutil.Named("post"))
+	rt.Get("/users/me", testutil.Named("me"))
+
+	out, req := dispatch(t, rt, "GET", "/users/42")
+	assert.True(t, strings.HasSuffix(out, "user"))
+	assert.Equal(t, "42", req.Params["id"])
+
+	out, req = dispatch(t, rt, "GET", "/users/42/posts/7")
+	assert.True(t, strings.HasSuffix(out, "post"))
+	assert.Equal(t, "42", req.Params["id"])
+	assert.Equal(t, "7", req.Params["post"])
+
+	out, _ = dispatch(t, rt, "GET", "/users/me")
+	assert.True(t, strings.HasSuffix(out, "me"))
+
+	out, req = dispatch(t, rt, "GET", "/users/jane%20doe")
+	assert.True(t, strings.HasSuffix(out, "user"))
+	assert.Equal(t, "jane doe", req.Params["id"])
+
+	out, req = dispatch(t, rt, "GET", "/users/a%2Fb")
+	assert.True(t, strings.HasSuffix(out, "user"))
+	assert.Equal(t, "a/b", req.Params["id"])
+
+	out, req = dispatch(t, rt, "GET", "/users/a%2Fb/posts/7")
+	assert.True(t, strings.HasSuffix(out, "post"))
+	assert.Equal(t, "a/b", req.Params["id"])
+}
+
+func TestWildcard(t *testing.T) {
+	rt := New()
+	rt.Get("/static/*", testutil.Named("static"))
+	rt.Get("/static", testutil.Named("index"))
+
+	out, req := dispatch(t, rt, "GET", "/static/css/site.css")
+	assert.True(t, strings.HasSuffix(out, "static"))
+	assert.Equal(t, "css/site.css", req.Params[WILDCARD])
+
+	out, _ = dispatch(t, rt, "GET", "/static")
+	assert.True(t, strings.HasSuffix(out, "index"))
+}
+
+func TestGroup(t *testing.T) {
+	rt := New()
+	api := rt.Group("/api")
+	v1 := api.Group("/v1")
+	v1.Get("/users/{id}", testutil.Named("v1 user"))
+
+	out, req := dispatch(t, rt, "GET", "/api/v1/users/3")
+	assert.True(t, strings.HasSuffix(out, "v1 user"))
+	assert.Equal(t, "3", req.Params["id"])
+}
+
+func TestNotFound(t *testing.T) {
+	rt := New()
+	rt.Get("/video", testutil.Named("video"))
+
+	out, _ := dispatch(t, rt, "GET", "/audio")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+
+	rt.NotFound = testutil.Named("custom")
+	out, _ = dispatch(t, rt, "GET", "/audio")
+	assert.True(t, strings.HasSuffix(out, "custom"))
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	rt := New()
+	rt.Get("/users/{id}", testutil.Named("get"))
+	rt.Delete("/users/{id}", testutil.Named("delete"))
+
+	out, _ := dispatch(t, rt, "POST", "/users/1")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, out, "Allow: DELETE, GET\r\n")
+}
+
+func TestInvalidPattern(t *testing.T) {
+	rt := New()
+	assert.Panics(t, func() { rt.Get("/static/*/css", testutil.Named("bad")) })
+	assert.Panics(t, func() { rt.Get("users", testutil.Named("bad")) })
+}
+
+func TestMiddlewares(t *testing.T) {
+	order := []string{}
+	tag := func(name string) server.Middleware {
+		return func(next server.Handler) server.Handler {
+			return func(w *response.Writer, req *request.Request) {
+				order = append(order, name)
+				next(w, req)
+			}
+		}
+	}
+
+	rt := New()
+	rt.Use(tag("router"))
+	api := rt.Group("/api")
+	api.Use(tag("api"))
+	api.Get("/users", testutil.Named("users"))
+	rt.Get("/health", testutil.Named("health"))
+
+	dispatch(t, rt, "GET", "/api/users")
+	assert.Equal(t, []string{"router", "api"}, order)
+
+	order = order[:0]
+	dispatch(t, rt, "GET", "/health")
+	assert.Equal(t, []string{"router"}, order)
+
+	order = order[:0]
+	dispatch(t, rt, "GET", "/missing")
+	assert.Equal(t, []string{"router"}, order)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+const DEFAULT_READ_HEADER_TIMEOUT = 10 * time.Second
+const DEFAULT_MAX_HEADER_BYTES = 1 << 20
+const DEFAULT_MAX_BODY_BYTES = 10 << 20
+const DEFAULT_MAX_DECODED_BODY_BYTES = 10 << 20
+
+// Config controls where the server listens and the limits it enforces on
+// each connection. A zero duration or limit disables that check.
+type Config struct {
+	// Addr is the TCP address to listen on, such as "127.0.0.1:42069".
+	Addr string
+	// ReadHeaderTimeout bounds reading the request line and headers once the
+	// first byte of a request arrived. Exceeding it answers 408.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds reading a whole request, body included. Exceeding it
+	// while reading the headers answers 408.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds writing each response.
+	WriteTimeout time.Duration
+	// IdleTimeout bounds how long a kept-alive connection waits for the next
+	// request.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes limits the request line and headers. Exceeding it
+	// answers 431.
+	MaxHeaderBytes int
+	// MaxBodyBytes limits the request body. Exceeding it answers 413.
+	MaxBodyBytes int64
+	// DecodeRequestBodies makes the server undo a gzip or deflate
+	// Content-Encoding before handlers read the body. Other codings are
+	// answered 415.
+	DecodeRequestBodies bool
+	// MaxDecodedBodyBytes limits a decoded request body, so that a small
+	// compressed body cannot expand without bound. Exceeding it answers 413.
+	MaxDecodedBodyBytes int64
+	// MaxConns limits the connections served at once. Connections over the
+	// limit are answered 503 and closed.
+	MaxConns int
+	// MaxRequestsPerConn limits the requests served on a single connection.
+	MaxRequestsPerConn int
+}
+
+// DefaultConfig returns the configuration used by Serve for the given port.
+func DefaultConfig(port int) Config {
+	return Config{
+		Addr:                fmt.Sprintf(":%d", port),
+		ReadHeaderTimeout:   DEFAULT_READ_HEADER_TIMEOUT,
+		IdleTimeout:         DEFAULT_IDLE_TIMEOUT,
+		MaxHeaderBytes:      DEFAULT_MAX_HEADER_BYTES,
+		MaxBodyBytes:        DEFAULT_MAX_BODY_BYTES,
+		MaxDecodedBodyBytes: DEFAULT_MAX_DECODED_BODY_BYTES,
+		MaxRequestsPerConn:  DEFAULT_MAX_REQUESTS_PER_CONNECTION,
+	}
+}
@@ -1,20 +1,53 @@
 package server
 
 import (
-	"fmt"
+	"context"
+	"crypto/tls"
+	"errors"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
+	"io"
 	"log"
 	"net"
+	"runtime/debug"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const BUFFER_SIZE = 1_024
+const DEFAULT_IDLE_TIMEOUT = 60 * time.Second
+const DEFAULT_MAX_REQUESTS_PER_CONNECTION = 100
+const SHUTDOWN_POLL_INTERVAL = 10 * time.Millisecond
+
+// REJECT_TIMEOUT bounds reading the request of a connection over
+// Config.MaxConns before it is answered 503.
+const REJECT_TIMEOUT = time.Second
+
+// MAX_REJECTING_CONNS bounds the connections over Config.MaxConns being
+// answered at once. Past it they are closed without an answer.
+const MAX_REJECTING_CONNS = 64
+
+type connState int
+
+const (
+	connStateIdle connState = iota
+	connStateActive
+)
 
 type Server struct {
-	listener net.Listener
-	handler  Handler
-	open     *atomic.Bool
+	listener    net.Listener
+	handler     Handler
+	open        *atomic.Bool
+	config      Config
+	activeConns atomic.Int64
+	// rejectingConns counts the connections being answered 503 because
+	// they are over MaxConns.
+	rejectingConns atomic.Int64
+
+	mu    sync.Mutex
+	conns map[net.Conn]connState
 }
 
 type HandlerError struct {
@@ -24,6 +57,46 @@ type HandlerError struct {
 
 type Handler func(w *response.Writer, req *request.Request)
 
+// Middleware wraps a Handler to add behaviour around it.
+type Middleware func(Handler) Handler
+
+// Chain wraps handler with middlewares so that the first one runs outermost.
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// AutoFramed adapts a handler that writes through a response.AutoWriter,
+// closing the writer once the handler returns so the body is framed.
+func AutoFramed(handler func(w *response.AutoWriter, req *request.Request)) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		aw := response.NewAutoWriter(w)
+		handler(aw, req)
+		if err := aw.Close(); err != nil {
+			log.Printf("error completing response: %v", err)
+		}
+	}
+}
+
+// decodeBodies undoes the Content-Encoding of request bodies before handler
+// runs, answering 415 with the supported codings when it cannot.
+func decodeBodies(handler Handler, maxBytes int64) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if err := req.DecodeBody(maxBytes); err != nil {
+			log.Printf("rejecting request from %s: %v", req.RemoteAddr, err)
+			w.SetHeader("Accept-Encoding", request.SUPPORTED_CONTENT_ENCODINGS)
+			HandlerError{
+				Status:  response.StatusCodeUnsupportedMediaType,
+				Message: "Unsupported Media Type",
+			}.WriteError(w)
+			return
+		}
+		handler(w, req)
+	}
+}
+
 func (he HandlerError) WriteError(w *response.Writer) {
 	body := []byte(he.Message)
 	contentLength := len(body)
@@ -35,56 +108,326 @@ func (he HandlerError) WriteError(w *response.Writer) {
 }
 
 func Serve(port int, handler Handler) (*Server, error) {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	return ServeConfig(DefaultConfig(port), handler)
+}
+
+func ServeConfig(config Config, handler Handler) (*Server, error) {
+	listener, err := net.Listen("tcp", config.Addr)
 	if err != nil {
 		return nil, err
 	}
+	return serveListener(listener, config, handler), nil
+}
+
+func serveListener(listener net.Listener, config Config, handler Handler) *Server {
 	open := atomic.Bool{}
 	open.Store(true)
 
+	if config.DecodeRequestBodies {
+		handler = decodeBodies(handler, config.MaxDecodedBodyBytes)
+	}
+
 	server := Server{
 		listener: listener,
 		handler:  handler,
 		open:     &open,
+		config:   config,
+		conns:    make(map[net.Conn]connState),
 	}
 
 	go server.listen()
 
-	return &server, nil
+	return &server
 }
 
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections and closes every open connection,
+// including those with a request in flight.
 func (s *Server) Close() error {
-	if err := s.listener.Close(); err != nil {
-		return err
+	s.open.Store(false)
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
 	}
+	return err
+}
+
+// Shutdown stops accepting connections, closes idle ones and waits for the
+// requests in flight to be answered. Connections are closed as soon as their
+// current response is done. If ctx expires first, Shutdown returns its error
+// and leaves the remaining connections open.
+func (s *Server) Shutdown(ctx context.Context) error {
 	s.open.Store(false)
-	return nil
+	err := s.listener.Close()
+
+	ticker := time.NewTicker(SHUTDOWN_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIdleConns closes connections waiting for a new request and reports
+// whether no connection is left.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, state := range s.conns {
+		if state == connStateIdle {
+			_ = conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) trackConn(conn net.Conn, state connState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state == connStateIdle && !s.open.Load() {
+		return false
+	}
+	s.conns[conn] = state
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
 }
 
 func (s *Server) listen() {
 	for {
 		conn, err := s.listener.Accept()
-		if err != nil && s.open.Load() {
+		if err != nil {
+			if !s.open.Load() {
+				return
+			}
 			log.Printf("failed to establish a connection: %v", err)
+			continue
 		}
 
+		if s.config.MaxConns > 0 && s.activeConns.Load() >= int64(s.config.MaxConns) {
+			if s.rejectingConns.Load() >= MAX_REJECTING_CONNS {
+				_ = conn.Close()
+				continue
+			}
+			s.rejectingConns.Add(1)
+			s.trackConn(conn, connStateActive)
+			go s.rejectOverLimit(conn)
+			continue
+		}
+		s.activeConns.Add(1)
 		go s.handle(conn)
 	}
 }
 
+// rejectOverLimit answers a connection over MaxConns with 503. The request
+// line and headers are read first, as closing with them unread would reset
+// the connection before the client got the answer.
+func (s *Server) rejectOverLimit(conn net.Conn) {
+	defer s.rejectingConns.Add(-1)
+	defer conn.Close()
+	defer s.untrackConn(conn)
+
+	_ = conn.SetReadDeadline(deadline(time.Now(), REJECT_TIMEOUT, s.config.ReadHeaderTimeout))
+	reader := request.NewReader(conn)
+	reader.MaxHeaderBytes = s.config.MaxHeaderBytes
+	if _, err := reader.StreamRequest(); errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return
+	}
+	s.rejectRequest(conn, response.StatusCodeServiceUnavailable, "Service Unavailable")
+}
+
 func (s *Server) handle(conn net.Conn) {
+	defer s.activeConns.Add(-1)
 	defer conn.Close()
+	defer s.untrackConn(conn)
+
+	reader := request.NewReader(conn)
+	reader.MaxHeaderBytes = s.config.MaxHeaderBytes
+	reader.MaxBodyBytes = s.config.MaxBodyBytes
+	for served := 1; ; served++ {
+		if !s.trackConn(conn, connStateIdle) {
+			return
+		}
+		_ = conn.SetReadDeadline(deadline(time.Now(), s.config.IdleTimeout))
+		if err := reader.WaitForData(); err != nil {
+			return
+		}
+		s.trackConn(conn, connStateActive)
+
+		start := time.Now()
+		_ = conn.SetReadDeadline(deadline(start, s.config.ReadHeaderTimeout, s.config.ReadTimeout))
+		req, err := reader.StreamRequest()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			status := parseErrorStatus(err)
+			log.Printf("rejecting request from %s with %d: %v", conn.RemoteAddr(), status, err)
+			s.rejectRequest(conn, status, response.StatusText(status))
+			return
+		}
+		_ = conn.SetReadDeadline(deadline(start, s.config.ReadTimeout))
+		_ = conn.SetWriteDeadline(deadline(time.Now(), s.config.WriteTimeout))
+
+		req.RemoteAddr = conn.RemoteAddr().String()
+
+		writer := response.NewWriter(conn)
+		writer.SetRequestVersion(req.RequestLine.VersionMajor, req.RequestLine.VersionMinor)
+		writer.SetRequestMethod(req.RequestLine.Method)
+		keepAlive := (s.config.MaxRequestsPerConn <= 0 || served < s.config.MaxRequestsPerConn) && !wantsClose(req) && s.open.Load()
+		if !keepAlive {
+			writer.CloseAfterResponse()
+		}
+
+		if !s.serve(conn, &writer, req) {
+			return
+		}
+		if writer.Status() == 0 && errors.Is(req.BodyReader.Close(), request.ErrBodyTooLarge) {
+			// the handler gave up on a body over the limit without answering
+			writer.CloseAfterResponse()
+			HandlerError{
+				Status:  response.StatusCodeContentTooLarge,
+				Message: "Content Too Large",
+			}.WriteError(&writer)
+			return
+		}
+		if err := writer.Complete(); err != nil && writer.Status() != 0 {
+			log.Printf("aborting connection to %s: %v", conn.RemoteAddr(), err)
+			abort(conn)
+			return
+		}
+		if !keepAlive || !writer.KeepAlive() {
+			return
+		}
+		if err := req.BodyReader.Close(); err != nil {
+			return
+		}
+	}
+}
+
+// serve runs the handler, recovering from a panic. It reports false when the
+// handler panicked, in which case the connection must not be reused.
+func (s *Server) serve(conn net.Conn, w *response.Writer, req *request.Request) (ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("panic serving %s %s for %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, conn.RemoteAddr(), err, debug.Stack())
+			ok = false
+
+			if w.Status() != 0 {
+				abort(conn)
+				return
+			}
+			w.CloseAfterResponse()
+			HandlerError{
+				Status:  response.StatusCodeInternalServerError,
+				Message: "Internal Server Error",
+			}.WriteError(w)
+		}
+	}()
 
+	s.handler(w, req)
+	return true
+}
+
+// abort makes closing conn reset it, for when the client already has part of
+// a response and must not mistake it for a complete one. A TLS connection is
+// closed underneath right away, as closing it would send close_notify first.
+func abort(conn net.Conn) {
+	if tlsConn, isTLS := conn.(*tls.Conn); isTLS {
+		conn = tlsConn.NetConn()
+		defer conn.Close()
+	}
+	if tcpConn, isTCP := conn.(*net.TCPConn); isTCP {
+		_ = tcpConn.SetLinger(0)
+	}
+}
+
+// rejectRequest answers with an error and marks the connection for closing.
+func (s *Server) rejectRequest(conn net.Conn, status response.StatusCode, message string) {
+	_ = conn.SetWriteDeadline(deadline(time.Now(), s.config.WriteTimeout))
 	writer := response.NewWriter(conn)
+	writer.CloseAfterResponse()
+	HandlerError{
+		Status:  status,
+		Message: message,
+	}.WriteError(&writer)
+}
 
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		HandlerError{
-			Status:  response.StatusCodeBadRequest,
-			Message: err.Error(),
-		}.WriteError(&writer)
-		return
+// parseErrorStatus maps a request parsing error to its status code. Clients
+// only get the standard reason phrase, never the parser's details.
+func parseErrorStatus(err error) response.StatusCode {
+	switch {
+	case isTimeout(err):
+		return response.StatusCodeRequestTimeout
+	case errors.Is(err, request.ErrHeaderTooLarge):
+		return response.StatusCodeRequestHeaderFieldsTooLarge
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.StatusCodeContentTooLarge
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		return response.StatusCodeHTTPVersionNotSupported
+	case errors.Is(err, request.ErrUnsupportedTransferEncoding):
+		return response.StatusCodeNotImplemented
+	default:
+		return response.StatusCodeBadRequest
 	}
+}
+
+// deadline returns the earliest of start plus each non-zero timeout, or the
+// zero time, which clears the deadline, when every timeout is zero.
+func deadline(start time.Time, timeouts ...time.Duration) time.Time {
+	var earliest time.Time
+	for _, timeout := range timeouts {
+		if timeout <= 0 {
+			continue
+		}
+		if candidate := start.Add(timeout); earliest.IsZero() || candidate.Before(earliest) {
+			earliest = candidate
+		}
+	}
+	return earliest
+}
+
+// wantsClose reports whether the client asked to close the connection after
+// this request. HTTP/1.0 clients have to opt in to keep-alive.
+func wantsClose(req *request.Request) bool {
+	if hasConnectionOption(req, "close") {
+		return true
+	}
+	return !req.RequestLine.AtLeast(1, 1) && !hasConnectionOption(req, "keep-alive")
+}
+
+func hasConnectionOption(req *request.Request, option string) bool {
+	value, ok := req.Headers.Get("Connection")
+	if !ok {
+		return false
+	}
+	for _, o := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(o), option) {
+			return true
+		}
+	}
+	return false
+}
 
-	s.handler(&writer, req)
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
@@ -0,0 +1,410 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T, handler Handler) *Server {
+	t.Helper()
+	return startServerConfig(t, DefaultConfig(0), handler)
+}
+
+func startServerConfig(t *testing.T, config Config, handler Handler) *Server {
+	t.Helper()
+	s, err := ServeConfig(config, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func dial(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func okHandler(w *response.Writer, req *request.Request) {
+	body := []byte(req.RequestLine.RequestTarget)
+	_ = w.WriteStatusLine(response.StatusCodeOK)
+	_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	_, _ = w.WriteBody(body)
+}
+
+func TestKeepAlive(t *testing.T) {
+	s := startServer(t, okHandler)
+	conn := dial(t, s)
+
+	_, err := io.WriteString(conn, "GET /first HTTP/1.1\r\nHost: localhost\r\n\r\nGET /second HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(out), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(string(out), "/second"))
+	assert.Equal(t, 1, strings.Count(string(out), "Connection: close\r\n"))
+}
+
+func TestPanicBeforeStatusLine(t *testing.T) {
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/" {
+			panic("boom")
+		}
+		okHandler(w, req)
+	})
+	conn := dial(t, s)
+
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error\r\n", status)
+
+	// the server keeps accepting connections
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET /after HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestPanicAfterStatusLine(t *testing.T) {
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		panic("boom")
+	})
+	conn := dial(t, s)
+
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+
+	// the partial response must not look complete: the connection is reset
+	out, err := io.ReadAll(conn)
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	assert.NotContains(t, string(out), "500")
+}
+
+func TestShutdownWaitsForActiveRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+		okHandler(w, req)
+	})
+	conn := dial(t, s)
+
+	_, err := io.WriteString(conn, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	done := make(chan error)
+	go func() { done <- s.Shutdown(context.Background()) }()
+
+	select {
+	case <-done:
+		t.Fatal("Shutdown returned while a request was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-done)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(string(out), "/slow"))
+
+	_, err = net.Dial("tcp", s.listener.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestShutdownClosesIdleConnections(t *testing.T) {
+	s := startServer(t, okHandler)
+	conn := dial(t, s)
+
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+
+	_, err = io.ReadAll(reader)
+	require.NoError(t, err)
+}
+
+func TestShutdownContextExpires(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+	})
+	conn := dial(t, s)
+
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+}
+
+func roundTrip(t *testing.T, s *Server, raw string) string {
+	t.Helper()
+	conn := dial(t, s)
+	_, err := io.WriteString(conn, raw)
+	require.NoError(t, err)
+	out, _ := io.ReadAll(conn)
+	return string(out)
+}
+
+func TestBindAddress(t *testing.T) {
+	config := DefaultConfig(0)
+	config.Addr = "127.0.0.1:0"
+	s := startServerConfig(t, config, okHandler)
+
+	host, _, err := net.SplitHostPort(s.listener.Addr().String())
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", host)
+}
+
+func TestHeaderTooLarge(t *testing.T) {
+	config := DefaultConfig(0)
+	config.MaxHeaderBytes = 64
+	s := startServerConfig(t, config, okHandler)
+
+	out := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Padding: "+strings.Repeat("a", 100)+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 431 Request Header Fields Too Large\r\n"))
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	config := DefaultConfig(0)
+	config.MaxBodyBytes = 8
+	s := startServerConfig(t, config, okHandler)
+
+	out := roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 13\r\n\r\nhello world!\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large\r\n"))
+
+	s = startServerConfig(t, config, func(w *response.Writer, req *request.Request) {
+		if _, err := req.ReadBody(); err != nil {
+			return
+		}
+		okHandler(w, req)
+	})
+	out = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\nD\r\nhello world!\n\r\n0\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large\r\n"))
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	config := DefaultConfig(0)
+	config.ReadHeaderTimeout = 20 * time.Millisecond
+	s := startServerConfig(t, config, okHandler)
+
+	out := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: local")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 408 Request Timeout\r\n"))
+}
+
+func TestIdleTimeout(t *testing.T) {
+	config := DefaultConfig(0)
+	config.IdleTimeout = 20 * time.Millisecond
+	s := startServerConfig(t, config, okHandler)
+
+	out := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.NotContains(t, out, "408")
+}
+
+func TestMaxConns(t *testing.T) {
+	config := DefaultConfig(0)
+	config.MaxConns = 1
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	s := startServerConfig(t, config, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+	})
+
+	conn := dial(t, s)
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	out := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"))
+}
+
+func TestMaxConnsRejectionIsTracked(t *testing.T) {
+	config := DefaultConfig(0)
+	config.MaxConns = 1
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	s := startServerConfig(t, config, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+	})
+
+	conn := dial(t, s)
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	// the rejected connection waits for its request, counted by Shutdown
+	rejected := dial(t, s)
+	openConns := func() int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.conns)
+	}
+	assert.Eventually(t, func() bool { return openConns() == 2 }, time.Second, time.Millisecond)
+
+	_, err = io.WriteString(rejected, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	out, err := io.ReadAll(rejected)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.Eventually(t, func() bool { return openConns() == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, int64(0), s.rejectingConns.Load())
+}
+
+func TestParseErrorStatus(t *testing.T) {
+	s := startServer(t, okHandler)
+
+	out := roundTrip(t, s, "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 505 HTTP Version Not Supported\r\n"))
+
+	out = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 501 Not Implemented\r\n"))
+
+	out = roundTrip(t, s, "GET / HTTP/1.1\r\nHost localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nBad Request"))
+
+	out = roundTrip(t, s, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+}
+
+func TestHTTP10ClosesByDefault(t *testing.T) {
+	s := startServer(t, okHandler)
+
+	out := roundTrip(t, s, "GET /old HTTP/1.0\r\n\r\nGET /ignored HTTP/1.0\r\n\r\n")
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "Connection: close\r\n")
+	assert.True(t, strings.HasSuffix(out, "/old"))
+}
+
+func TestHTTP10KeepAlive(t *testing.T) {
+	s := startServer(t, okHandler)
+
+	out := roundTrip(t, s, "GET /first HTTP/1.0\r\nConnection: keep-alive\r\n\r\nGET /second HTTP/1.0\r\n\r\n")
+	assert.Equal(t, 2, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "Connection: keep-alive\r\n")
+	assert.True(t, strings.HasSuffix(out, "/second"))
+}
+
+func TestHTTP10ChunkedFallsBackToCloseDelimited(t *testing.T) {
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Done")
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteChunkedBody([]byte("hello "))
+		_, _ = w.WriteChunkedBody([]byte("world"))
+		_, _ = w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Done", "yes")
+		_ = w.WriteTrailers(trailers)
+	})
+
+	out := roundTrip(t, s, "GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n")
+	assert.NotContains(t, out, "Transfer-Encoding")
+	assert.Contains(t, out, "Connection: close\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello world"))
+}
+
+func TestAutoFramed(t *testing.T) {
+	s := startServer(t, AutoFramed(func(w *response.AutoWriter, req *request.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, strings.TrimPrefix(req.RequestLine.Path, "/"))
+	}))
+
+	out := roundTrip(t, s, "GET /first HTTP/1.1\r\nHost: localhost\r\n\r\nGET /second HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Equal(t, 2, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "Content-Length: 5\r\n\r\nfirst")
+	assert.True(t, strings.HasSuffix(out, "Content-Length: 6\r\nConnection: close\r\n\r\nsecond"))
+}
+
+func TestShortBodyAbortsConnection(t *testing.T) {
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(10))
+		_, _ = w.WriteBody([]byte("short"))
+	})
+
+	out := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nshort"))
+}
+
+func TestDecodeRequestBodies(t *testing.T) {
+	config := DefaultConfig(0)
+	config.DecodeRequestBodies = true
+	config.MaxDecodedBodyBytes = 1_024
+	s := startServerConfig(t, config, func(w *response.Writer, req *request.Request) {
+		body, err := req.ReadBody()
+		if err != nil {
+			return
+		}
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		_, _ = w.WriteBody(body)
+	})
+
+	var buffer bytes.Buffer
+	gz := gzip.NewWriter(&buffer)
+	_, _ = io.WriteString(gz, "hello world")
+	require.NoError(t, gz.Close())
+	out := roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\nContent-Encoding: gzip\r\nContent-Length: "+strconv.Itoa(buffer.Len())+"\r\n\r\n"+buffer.String())
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello world"))
+
+	out = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\nContent-Encoding: br\r\nContent-Length: 3\r\n\r\nabc")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 415 Unsupported Media Type\r\n"))
+	assert.Contains(t, out, "Accept-Encoding: gzip, deflate\r\n")
+
+	buffer.Reset()
+	gz = gzip.NewWriter(&buffer)
+	_, _ = gz.Write(make([]byte, 1<<20))
+	require.NoError(t, gz.Close())
+	out = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: gzip\r\nContent-Length: "+strconv.Itoa(buffer.Len())+"\r\n\r\n"+buffer.String())
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large\r\n"))
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const HTTPS_PORT = 443
+
+// ServeTLS serves over TLS, wrapping the listener configured by config with
+// tlsConfig. Use CertificateStore.TLSConfig for certificates loaded from
+// files with SNI selection and hot reload.
+func ServeTLS(config Config, tlsConfig *tls.Config, handler Handler) (*Server, error) {
+	if tlsConfig == nil || (len(tlsConfig.Certificates) == 0 && tlsConfig.GetCertificate == nil && tlsConfig.GetConfigForClient == nil) {
+		return nil, fmt.Errorf("TLS config has no certificate")
+	}
+
+	listener, err := net.Listen("tcp", config.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return serveListener(tls.NewListener(listener, tlsConfig), config, handler), nil
+}
+
+// ServeRedirect answers every request on config.Addr with a permanent
+// redirect to the same host and target over HTTPS on httpsPort.
+func ServeRedirect(config Config, httpsPort int) (*Server, error) {
+	return ServeConfig(config, RedirectToHTTPS(httpsPort))
+}
+
+// RedirectToHTTPS returns a handler that redirects to the request's host and
+// target over HTTPS on httpsPort.
+func RedirectToHTTPS(httpsPort int) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if req.Host.Name == "" {
+			HandlerError{
+				Status:  response.StatusCodeBadRequest,
+				Message: "Missing Host header",
+			}.WriteError(w)
+			return
+		}
+
+		host := req.Host.Name
+		if httpsPort != HTTPS_PORT {
+			host = fmt.Sprintf("%s:%d", host, httpsPort)
+		}
+
+		target := req.RequestLine.RawPath
+		if target == "" {
+			target = "/"
+		}
+		if req.RequestLine.RawQuery != "" {
+			target += "?" + req.RequestLine.RawQuery
+		}
+
+		h := response.GetDefaultHeaders(0)
+		h.Set("Location", fmt.Sprintf("https://%s%s", host, target))
+		_ = w.WriteStatusLine(response.StatusCodePermanentRedirect)
+		_ = w.WriteHeaders(h)
+	}
+}
+
+// CertificateFiles names a PEM certificate chain and its private key.
+type CertificateFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+// CertificateStore holds certificates loaded from files and picks one per
+// connection by SNI server name. Reload and Watch replace them without
+// restarting the server.
+type CertificateStore struct {
+	files []CertificateFiles
+
+	mu           sync.RWMutex
+	certificates []*tls.Certificate
+	byName       map[string]*tls.Certificate
+	modTimes     []time.Time
+}
+
+// LoadCertificates loads every certificate pair. The first one is served to
+// clients whose server name matches no certificate.
+func LoadCertificates(files ...CertificateFiles) (*CertificateStore, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no certificate files given")
+	}
+	store := &CertificateStore{files: files}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reload reads every certificate pair again. On error the certificates in use
+// are kept.
+func (cs *CertificateStore) Reload() error {
+	certificates := make([]*tls.Certificate, 0, len(cs.files))
+	byName := make(map[string]*tls.Certificate)
+	modTimes := make([]time.Time, 0, len(cs.files))
+
+	for _, files := range cs.files {
+		modTime, err := latestModTime(files)
+		if err != nil {
+			return err
+		}
+		certificate, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+		if err != nil {
+			return fmt.Errorf("loading certificate %s: %w", files.CertFile, err)
+		}
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("parsing certificate %s: %w", files.CertFile, err)
+		}
+		certificate.Leaf = leaf
+
+		names := leaf.DNSNames
+		if len(names) == 0 && leaf.Subject.CommonName != "" {
+			names = []string{leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if _, exists := byName[name]; !exists {
+				byName[name] = &certificate
+			}
+		}
+		certificates = append(certificates, &certificate)
+		modTimes = append(modTimes, modTime)
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.certificates = certificates
+	cs.byName = byName
+	cs.modTimes = modTimes
+	return nil
+}
+
+// Watch polls the certificate files every interval and reloads them when one
+// changes, until ctx is done. Reload errors are passed to onError, which may
+// be nil.
+func (cs *CertificateStore) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !cs.changed() {
+			continue
+		}
+		if err := cs.Reload(); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+func (cs *CertificateStore) changed() bool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	for i, files := range cs.files {
+		modTime, err := latestModTime(files)
+		if err != nil || !modTime.Equal(cs.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCertificate selects a certificate by exact server name, then by a
+// wildcard name covering it, then falls back to the first certificate.
+func (cs *CertificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if certificate, ok := cs.byName[name]; ok {
+		return certificate, nil
+	}
+	if _, parent, found := strings.Cut(name, "."); found {
+		if certificate, ok := cs.byName["*."+parent]; ok {
+			return certificate, nil
+		}
+	}
+	return cs.certificates[0], nil
+}
+
+// TLSConfig returns a TLS configuration serving the store's certificates.
+func (cs *CertificateStore) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: cs.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+func latestModTime(files CertificateFiles) (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{files.CertFile, files.KeyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSelfSigned writes a self-signed certificate for names into dir and
+// returns the file pair together with the parsed certificate.
+func writeSelfSigned(t *testing.T, dir, prefix string, names ...string) (CertificateFiles, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: names[0]},
+		DNSNames:              names,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	files := CertificateFiles{
+		CertFile: filepath.Join(dir, prefix+".crt"),
+		KeyFile:  filepath.Join(dir, prefix+".key"),
+	}
+	require.NoError(t, os.WriteFile(files.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(files.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+	return files, certificate
+}
+
+func startTLSServer(t *testing.T, tlsConfig *tls.Config, handler Handler) *Server {
+	t.Helper()
+	config := DefaultConfig(0)
+	config.Addr = "127.0.0.1:0"
+	s, err := ServeTLS(config, tlsConfig, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func dialTLS(t *testing.T, s *Server, serverName string, roots ...*x509.Certificate) *tls.Conn {
+	t.Helper()
+	pool := x509.NewCertPool()
+	for _, root := range roots {
+		pool.AddCert(root)
+	}
+	conn, err := tls.Dial("tcp", s.listener.Addr().String(), &tls.Config{ServerName: serverName, RootCAs: pool})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestServeTLS(t *testing.T) {
+	files, certificate := writeSelfSigned(t, t.TempDir(), "site", "localhost")
+	store, err := LoadCertificates(files)
+	require.NoError(t, err)
+	s := startTLSServer(t, store.TLSConfig(), okHandler)
+
+	conn := dialTLS(t, s, "localhost", certificate)
+	_, err = io.WriteString(conn, "GET /secure HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(string(out), "/secure"))
+}
+
+func TestShortBodyAbortsTLSConnection(t *testing.T) {
+	files, certificate := writeSelfSigned(t, t.TempDir(), "site", "localhost")
+	store, err := LoadCertificates(files)
+	require.NoError(t, err)
+	s := startTLSServer(t, store.TLSConfig(), func(w *response.Writer, req *request.Request) {
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(10))
+		_, _ = w.WriteBody([]byte("short"))
+	})
+
+	conn := dialTLS(t, s, "localhost", certificate)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+
+	// a close_notify would end the response cleanly: the connection is reset
+	out, err := io.ReadAll(conn)
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\nshort"))
+}
+
+func TestServeTLSRequiresCertificate(t *testing.T) {
+	_, err := ServeTLS(DefaultConfig(0), &tls.Config{}, okHandler)
+	assert.Error(t, err)
+}
+
+func TestSNICertificateSelection(t *testing.T) {
+	dir := t.TempDir()
+	aFiles, aCertificate := writeSelfSigned(t, dir, "a", "a.test")
+	bFiles, bCertificate := writeSelfSigned(t, dir, "b", "*.b.test")
+	store, err := LoadCertificates(aFiles, bFiles)
+	require.NoError(t, err)
+	s := startTLSServer(t, store.TLSConfig(), okHandler)
+
+	conn := dialTLS(t, s, "a.test", aCertificate)
+	assert.Equal(t, aCertificate.Raw, conn.ConnectionState().PeerCertificates[0].Raw)
+
+	conn = dialTLS(t, s, "www.b.test", bCertificate)
+	assert.Equal(t, bCertificate.Raw, conn.ConnectionState().PeerCertificates[0].Raw)
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	files, oldCertificate := writeSelfSigned(t, dir, "site", "localhost")
+	store, err := LoadCertificates(files)
+	require.NoError(t, err)
+	s := startTLSServer(t, store.TLSConfig(), okHandler)
+
+	conn := dialTLS(t, s, "localhost", oldCertificate)
+	assert.Equal(t, oldCertificate.Raw, conn.ConnectionState().PeerCertificates[0].Raw)
+
+	_, newCertificate := writeSelfSigned(t, dir, "site", "localhost")
+	require.NoError(t, store.Reload())
+
+	conn = dialTLS(t, s, "localhost", newCertificate)
+	assert.Equal(t, newCertificate.Raw, conn.ConnectionState().PeerCertificates[0].Raw)
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	s, err := ServeRedirect(DefaultConfig(0), 8443)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	out := roundTrip(t, s, "GET /path?q=1 HTTP/1.1\r\nHost: example.com:8080\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 308 Permanent Redirect\r\n"))
+	assert.Contains(t, out, "Location: https://example.com:8443/path?q=1\r\n")
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"slices"
+	"strings"
+)
+
+type wildcardHost struct {
+	// suffix includes the leading dot, ".example.com"
+	suffix  string
+	handler Handler
+}
+
+// VirtualHosts dispatches requests to a handler chosen by the name in
+// Request.Host, ignoring the port. VirtualHosts.Route is a Handler, so it
+// can be passed to Serve.
+type VirtualHosts struct {
+	exact     map[string]Handler
+	wildcards []wildcardHost
+	// Default serves requests for hosts that match no name.
+	Default Handler
+	// UnknownHost answers requests that match no name when there is no
+	// Default. It defaults to 421 Misdirected Request.
+	UnknownHost Handler
+}
+
+func NewVirtualHosts() *VirtualHosts {
+	return &VirtualHosts{exact: map[string]Handler{}}
+}
+
+// Handle registers handler for an exact host name, or for every subdomain
+// of a name with a "*.example.com" pattern. The most specific wildcard wins
+// when several cover a host, and an exact name beats any wildcard.
+func (vh *VirtualHosts) Handle(pattern string, handler Handler) {
+	name := normalizeHostName(pattern)
+	if parent, found := strings.CutPrefix(name, "*."); found {
+		if parent == "" || strings.Contains(parent, "*") {
+			panic(fmt.Sprintf("invalid host pattern %q", pattern))
+		}
+		vh.wildcards = append(vh.wildcards, wildcardHost{suffix: "." + parent, handler: handler})
+		slices.SortStableFunc(vh.wildcards, func(a, b wildcardHost) int {
+			return len(b.suffix) - len(a.suffix)
+		})
+		return
+	}
+	if name == "" || strings.Contains(name, "*") {
+		panic(fmt.Sprintf("invalid host pattern %q", pattern))
+	}
+	vh.exact[name] = handler
+}
+
+func (vh *VirtualHosts) Route(w *response.Writer, req *request.Request) {
+	if handler, ok := vh.match(req.Host.Name); ok {
+		handler(w, req)
+		return
+	}
+	switch {
+	case vh.Default != nil:
+		vh.Default(w, req)
+	case vh.UnknownHost != nil:
+		vh.UnknownHost(w, req)
+	default:
+		HandlerError{
+			Status:  response.StatusCodeMisdirectedRequest,
+			Message: response.StatusText(response.StatusCodeMisdirectedRequest),
+		}.WriteError(w)
+	}
+}
+
+func (vh *VirtualHosts) match(host string) (Handler, bool) {
+	name := normalizeHostName(host)
+	if handler, ok := vh.exact[name]; ok {
+		return handler, true
+	}
+	for _, wildcard := range vh.wildcards {
+		if strings.HasSuffix(name, wildcard.suffix) {
+			return wildcard.handler, true
+		}
+	}
+	return nil, false
+}
+
+// normalizeHostName lower-cases a name and drops the trailing dot of a fully
+// qualified name, so "Example.com." and "example.com" are the same host.
+func normalizeHostName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
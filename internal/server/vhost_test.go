@@ -0,0 +1,62 @@
+package server
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/testutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func routeHost(t *testing.T, vh *VirtualHosts, host string) string {
+	t.Helper()
+	return testutil.Serve(t, vh.Route, testutil.RawRequest("GET", "/", "Host: "+host))
+}
+
+func TestVirtualHosts(t *testing.T) {
+	vh := NewVirtualHosts()
+	vh.Handle("example.com", testutil.Named("apex"))
+	vh.Handle("*.example.com", testutil.Named("wildcard"))
+	vh.Handle("*.api.example.com", testutil.Named("api"))
+	vh.Handle("www.example.com", testutil.Named("www"))
+
+	assert.True(t, strings.HasSuffix(routeHost(t, vh, "example.com"), "apex"))
+	assert.True(t, strings.HasSuffix(routeHost(t, vh, "Example.COM.:8080"), "apex"))
+	assert.True(t, strings.HasSuffix(routeHost(t, vh, "www.example.com"), "www"))
+	assert.True(t, strings.HasSuffix(routeHost(t, vh, "blog.example.com"), "wildcard"))
+	assert.True(t, strings.HasSuffix(routeHost(t, vh, "a.b.example.com"), "wildcard"))
+	assert.True(t, strings.HasSuffix(routeHost(t, vh, "v1.api.example.com"), "api"))
+
+	out := routeHost(t, vh, "other.test")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 421 Misdirected Request\r\n"))
+
+	vh.UnknownHost = func(w *response.Writer, req *request.Request) {
+		HandlerError{Status: response.StatusCodeNotFound, Message: "no such site"}.WriteError(w)
+	}
+	out = routeHost(t, vh, "other.test")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+	assert.True(t, strings.HasSuffix(out, "no such site"))
+
+	vh.Default = testutil.Named("default")
+	assert.True(t, strings.HasSuffix(routeHost(t, vh, "other.test"), "default"))
+}
+
+func TestVirtualHostsInvalidPattern(t *testing.T) {
+	vh := NewVirtualHosts()
+	assert.Panics(t, func() { vh.Handle("", testutil.Named("empty")) })
+	assert.Panics(t, func() { vh.Handle("*.", testutil.Named("bare")) })
+	assert.Panics(t, func() { vh.Handle("a.*.com", testutil.Named("middle")) })
+}
+
+func TestServeVirtualHosts(t *testing.T) {
+	vh := NewVirtualHosts()
+	vh.Handle("a.test", testutil.Named("a"))
+	vh.Handle("b.test", testutil.Named("b"))
+	s := startServer(t, vh.Route)
+
+	out := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: a.test\r\n\r\nGET / HTTP/1.1\r\nHost: b.test\r\nConnection: close\r\n\r\n")
+	assert.Contains(t, out, "\r\n\r\na")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nb"))
+}
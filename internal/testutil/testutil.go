@@ -0,0 +1,90 @@
+// Package testutil holds the request and response fixtures that the handler
+// packages' tests share: building raw requests, running a handler on one
+// without a connection, and canned handlers. It does not import server, so
+// that the server tests can use it.
+package testutil
+
+import (
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+	"testing"
+)
+
+// REMOTE_ADDR is the client address ServeRequest gives requests.
+const REMOTE_ADDR = "192.0.2.1:54321"
+
+// Handler has the shape of server.Handler, which converts to and from it.
+type Handler = func(w *response.Writer, req *request.Request)
+
+// RawRequest builds an HTTP/1.1 request without a body from header lines
+// such as "Accept: text/html". It adds "Host: localhost" unless a Host line
+// is given.
+func RawRequest(method, target string, headerLines ...string) string {
+	raw := method + " " + target + " HTTP/1.1\r\n"
+	hasHost := false
+	for _, line := range headerLines {
+		name, _, _ := strings.Cut(line, ":")
+		hasHost = hasHost || strings.EqualFold(name, "Host")
+		raw += line + "\r\n"
+	}
+	if !hasHost {
+		raw = strings.Replace(raw, "\r\n", "\r\nHost: localhost\r\n", 1)
+	}
+	return raw + "\r\n"
+}
+
+// ParseRequest parses raw with its body buffered, failing the test if it is
+// not a valid request.
+func ParseRequest(t testing.TB, raw string) *request.Request {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("parsing request %q: %v", raw, err)
+	}
+	return req
+}
+
+// Serve parses raw and runs handler on it. See ServeRequest.
+func Serve(t testing.TB, handler Handler, raw string) string {
+	t.Helper()
+	return ServeRequest(t, handler, ParseRequest(t, raw))
+}
+
+// ServeRequest runs handler on req the way the server would, with
+// REMOTE_ADDR as the client and the writer told the request method, and
+// returns the response it wrote.
+func ServeRequest(t testing.TB, handler Handler, req *request.Request) string {
+	t.Helper()
+	req.RemoteAddr = REMOTE_ADDR
+
+	var buffer bytes.Buffer
+	w := response.NewWriter(&buffer)
+	w.SetRequestMethod(req.RequestLine.Method)
+	handler(&w, req)
+	return buffer.String()
+}
+
+// SplitResponse returns the status line and headers of a response, ending
+// with a CRLF, and its body as written.
+func SplitResponse(out string) (head, body string) {
+	head, body, _ = strings.Cut(out, "\r\n\r\n")
+	return head + "\r\n", body
+}
+
+// Body returns the body of a response as written.
+func Body(out string) string {
+	_, body := SplitResponse(out)
+	return body
+}
+
+// Named returns a handler answering 200 with name as a text/plain body.
+func Named(name string) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		body := []byte(name)
+		_ = w.WriteStatusLine(response.StatusCodeOK)
+		_ = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		_, _ = w.WriteBody(body)
+	}
+}